@@ -0,0 +1,207 @@
+package main
+
+// Camera is the part of the world on screen. Left, Top, Right and Bottom are
+// the view in world pixels, and everything after them is the follow state
+// Update keeps between steps. it's all plain values so it lives in GameState
+// and gets interpolated, saved and replayed along with everything else
+type Camera struct {
+	Left   int
+	Right  int
+	Top    int
+	Bottom int
+	Bounds Size  // the whole map
+	Size   Size  // the view, which is the screen divided by the zoom
+	Screen Size  // the screen in pixels
+	Zoom   int32 // screen pixels per world pixel, always whole so pixels stay square. 1 in the state
+	Room   Rect  // the part of the map the view is kept inside right now
+
+	Focus  Vec2  // middle of the view, before shaking
+	Vel    Vec2  // of the focus, for the spring
+	Goal   Vec2  // where the dead zone is
+	Look   Vec2  // how far ahead of the target the view is leading
+	Trauma Fixed // 0 to 1, shake is this squared so it eases off at the end
+	Shake  Vector
+}
+
+// CameraConfig is how a camera follows its target. distances are in world
+// pixels and times in steps
+type CameraConfig struct {
+	DeadZone   Size  // the target moves this much around the middle before the camera follows
+	Stiffness  Fixed // of the spring pulling the view towards the target, per step
+	LookAhead  Fixed // steps of the target's velocity to lead it by
+	MaxLook    Fixed // furthest to lead by
+	LookSpeed  Fixed // how fast the lead changes, pixels per step
+	MaxShake   Fixed // furthest the view is thrown by a full shake
+	ShakeDecay Fixed // trauma lost per step
+}
+
+var DEFAULT_CAMERA = CameraConfig{
+	DeadZone:   Size{40, 48},
+	Stiffness:  FxFloat(0.08),
+	LookAhead:  Fx(40),
+	MaxLook:    Fx(50),
+	LookSpeed:  FxFloat(0.75),
+	MaxShake:   Fx(8),
+	ShakeDecay: FxFloat(1.0 / 90),
+}
+
+// map objects of this type are rectangles the camera stays inside while its
+// target is in them, like rooms in a building
+const CAMERA_ROOM = "camera_room"
+
+func (s *Camera) Set(pos Vector) {
+	s.Left = clamp(0, int(pos.X), int(s.Bounds.W-s.Size.W))
+	s.Top = clamp(0, int(pos.Y), int(s.Bounds.H-s.Size.H))
+	s.Right = s.Left + int(s.Size.W)
+	s.Bottom = s.Top + int(s.Size.H)
+}
+
+// the size of the screen the view is drawn on
+func (s *Camera) SetSize(sz Size) {
+	s.Screen = sz
+	s.resize()
+}
+
+func (s *Camera) SetBounds(b Size) {
+	s.Bounds = b
+	s.Room = Rect{0, 0, b.W, b.H}
+	s.Set(Vector{int32(s.Left), int32(s.Top)})
+}
+
+func (s *Camera) SetZoom(zoom int32) {
+	s.Zoom = zoom
+	s.resize()
+}
+
+// keep the middle of the view where it was when its size changes
+func (s *Camera) resize() {
+	if s.Zoom < 1 {
+		s.Zoom = 1
+	}
+	mid := Vector{int32(s.Left+s.Right) / 2, int32(s.Top+s.Bottom) / 2}
+	s.Size = Size{s.Screen.W / s.Zoom, s.Screen.H / s.Zoom}
+	s.Set(Vector{mid.X - s.Size.W/2, mid.Y - s.Size.H/2})
+}
+
+// from world pixels to screen pixels
+func (s *Camera) ToScreen(pos Vector) Vector {
+	return Vector{(pos.X - int32(s.Left)) * s.Zoom, (pos.Y - int32(s.Top)) * s.Zoom}
+}
+
+// from screen pixels to world pixels
+func (s *Camera) ToWorld(pos Vector) Vector {
+	return Vector{pos.X/s.Zoom + int32(s.Left), pos.Y/s.Zoom + int32(s.Top)}
+}
+
+func (s *Camera) ScaleSize(sz Size) Size {
+	return Size{sz.W * s.Zoom, sz.H * s.Zoom}
+}
+
+// shake the view. amounts add up to at most a full shake
+func (s *Camera) AddShake(amount Fixed) {
+	s.Trauma += amount
+	if s.Trauma > FIXED_ONE {
+		s.Trauma = FIXED_ONE
+	}
+}
+
+// jump straight to looking at the target, for when a level starts
+func (s *Camera) Snap(cfg *CameraConfig, target *Entity, rooms []Rect) {
+	s.Goal = entityMiddle(target)
+	s.Look = Vec2{}
+	s.Vel = Vec2{}
+	s.Room = s.roomAt(s.Goal, rooms)
+	s.Focus = s.keepInRoom(s.Goal)
+	s.place()
+}
+
+// move the view one step closer to where it should be for the target. target
+// can be nil, in which case the view stays where it is but still shakes
+func (s *Camera) Update(cfg *CameraConfig, target *Entity, rooms []Rect, random func(n int) int) {
+	want := s.Focus
+	if target != nil {
+		mid := entityMiddle(target)
+		s.Room = s.roomAt(mid, rooms)
+
+		// the goal only moves when the target pushes on the edge of the dead zone
+		half := Vec2{Fx(int(cfg.DeadZone.W)) / 2, Fx(int(cfg.DeadZone.H)) / 2}
+		s.Goal.X = clampFixed(s.Goal.X, mid.X-half.X, mid.X+half.X)
+		s.Goal.Y = clampFixed(s.Goal.Y, mid.Y-half.Y, mid.Y+half.Y)
+
+		// lead the target the way it's going, sideways only since looking
+		// ahead while jumping makes the view bob
+		lead := clampFixed(target.Vel.X.Mul(cfg.LookAhead), -cfg.MaxLook, cfg.MaxLook)
+		s.Look.X = s.Look.X.Approach(lead, cfg.LookSpeed)
+
+		want = s.keepInRoom(s.Goal.Add(s.Look))
+	}
+
+	// a critically damped spring gets there as fast as it can without
+	// overshooting
+	k := cfg.Stiffness
+	acc := want.Sub(s.Focus).Scale(k.Mul(k)).Sub(s.Vel.Scale(2 * k))
+	s.Vel = s.Vel.Add(acc)
+	s.Focus = s.Focus.Add(s.Vel)
+
+	s.Shake = Vector{}
+	if s.Trauma > 0 {
+		amount := cfg.MaxShake.Mul(s.Trauma.Mul(s.Trauma)).Int()
+		if amount > 0 {
+			s.Shake = Vector{int32(random(int(2*amount+1))) - amount, int32(random(int(2*amount+1))) - amount}
+		}
+		s.Trauma -= cfg.ShakeDecay
+		if s.Trauma < 0 {
+			s.Trauma = 0
+		}
+	}
+
+	s.place()
+}
+
+// set the view from the focus and the shake
+func (s *Camera) place() {
+	pos := s.Focus.Pixels()
+	s.Set(Vector{pos.X - s.Size.W/2 + s.Shake.X, pos.Y - s.Size.H/2 + s.Shake.Y})
+}
+
+// the room holding a point, or the whole map if none of them do
+func (s *Camera) roomAt(p Vec2, rooms []Rect) Rect {
+	px := p.Pixels()
+	for _, r := range rooms {
+		if r.Contains(px) {
+			return r
+		}
+	}
+	return Rect{0, 0, s.Bounds.W, s.Bounds.H}
+}
+
+// move a focus point so the view around it stays inside the room, or is in
+// the middle of a room smaller than the view
+func (s *Camera) keepInRoom(p Vec2) Vec2 {
+	r := s.Room
+	if r.W <= s.Size.W {
+		p.X = Fx(int(r.X + r.W/2))
+	} else {
+		p.X = clampFixed(p.X, Fx(int(r.X+s.Size.W/2)), Fx(int(r.X+r.W-s.Size.W/2)))
+	}
+	if r.H <= s.Size.H {
+		p.Y = Fx(int(r.Y + r.H/2))
+	} else {
+		p.Y = clampFixed(p.Y, Fx(int(r.Y+s.Size.H/2)), Fx(int(r.Y+r.H-s.Size.H/2)))
+	}
+	return p
+}
+
+func entityMiddle(ent *Entity) Vec2 {
+	return Vec2{ent.Pos.X + Fx(int(ent.Size.W))/2, ent.Pos.Y + Fx(int(ent.Size.H))/2}
+}
+
+func clampFixed(f, lo, hi Fixed) Fixed {
+	if f < lo {
+		return lo
+	}
+	if f > hi {
+		return hi
+	}
+	return f
+}
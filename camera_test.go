@@ -0,0 +1,161 @@
+package main
+
+import "testing"
+
+// a 320x200 view on a 2000x1000 map looking at a target standing still
+func newTestCamera(target *Entity, rooms []Rect) *Camera {
+	cam := &Camera{}
+	cam.SetSize(Size{320, 200})
+	cam.SetBounds(Size{2000, 1000})
+	cam.Snap(&DEFAULT_CAMERA, target, rooms)
+	return cam
+}
+
+func testTarget(x, y int) *Entity {
+	return &Entity{Pos: Vec2{Fx(x), Fx(y)}, Size: Size{16, 32}}
+}
+
+func noShake(n int) int { return n / 2 }
+
+func stepCamera(cam *Camera, target *Entity, rooms []Rect, n int) {
+	for i := 0; i < n; i++ {
+		cam.Update(&DEFAULT_CAMERA, target, rooms, noShake)
+	}
+}
+
+func TestCameraDeadZone(t *testing.T) {
+	target := testTarget(1000, 500)
+	cam := newTestCamera(target, nil)
+	left, top := cam.Left, cam.Top
+
+	// moving around inside the dead zone leaves the view alone
+	half := int(DEFAULT_CAMERA.DeadZone.W) / 2
+	target.Pos.X += Fx(half - 1)
+	stepCamera(cam, target, nil, 200)
+	if cam.Left != left || cam.Top != top {
+		t.Fatalf("view moved to %d,%d from %d,%d inside the dead zone", cam.Left, cam.Top, left, top)
+	}
+
+	// past the edge it follows by however far the target went over
+	target.Pos.X += Fx(10)
+	stepCamera(cam, target, nil, 200)
+	if d := cam.Left - left; d < 8 || d > 10 {
+		t.Errorf("view moved %d pixels for a target 9 past the dead zone", d)
+	}
+	if cam.Top != top {
+		t.Errorf("view moved up or down following the target sideways")
+	}
+}
+
+func TestCameraSpring(t *testing.T) {
+	target := testTarget(500, 500)
+	cam := newTestCamera(target, nil)
+	start := cam.Focus.X
+
+	target.Pos.X += Fx(300)
+	want := start + Fx(300-int(DEFAULT_CAMERA.DeadZone.W)/2)
+
+	// gets there without ever going past, bar rounding, and a long way there
+	// early on
+	last := start
+	for i := 0; i < 300; i++ {
+		cam.Update(&DEFAULT_CAMERA, target, nil, noShake)
+		if cam.Focus.X < last-FIXED_ONE/16 {
+			t.Fatalf("step %d: view went back from %v to %v", i, last.Float(), cam.Focus.X.Float())
+		}
+		if cam.Focus.X > want+FIXED_ONE/16 {
+			t.Fatalf("step %d: view went past the target to %v", i, cam.Focus.X.Float())
+		}
+		if i == 30 && cam.Focus.X-start < (want-start)/2 {
+			t.Errorf("view only got %v of the way in 30 steps", (cam.Focus.X - start).Float())
+		}
+		last = cam.Focus.X
+	}
+	if d := (want - cam.Focus.X).Int(); d > 1 {
+		t.Errorf("view still %d pixels off after 300 steps", d)
+	}
+}
+
+func TestCameraRooms(t *testing.T) {
+	rooms := []Rect{
+		{400, 400, 600, 300},  // bigger than the view
+		{1200, 200, 200, 100}, // smaller than it
+	}
+
+	// in a room the view stays inside it however close to the edge the target is
+	target := testTarget(410, 420)
+	cam := newTestCamera(target, rooms)
+	stepCamera(cam, target, rooms, 100)
+	if cam.Left != 400 || cam.Top != 400 {
+		t.Errorf("view at %d,%d, want the top left of the room", cam.Left, cam.Top)
+	}
+
+	// a room smaller than the view gets centred in it
+	target = testTarget(1300, 250)
+	cam = newTestCamera(target, rooms)
+	if mid := (cam.Left + cam.Right) / 2; mid != 1300 {
+		t.Errorf("view centred on %d across a room centred on 1300", mid)
+	}
+	if mid := (cam.Top + cam.Bottom) / 2; mid != 250 {
+		t.Errorf("view centred on %d down a room centred on 250", mid)
+	}
+
+	// outside them the whole map is the room, and the view never leaves it
+	target = testTarget(0, 0)
+	cam = newTestCamera(target, rooms)
+	stepCamera(cam, target, rooms, 100)
+	if cam.Left != 0 || cam.Top != 0 {
+		t.Errorf("view at %d,%d off the map", cam.Left, cam.Top)
+	}
+}
+
+func TestCameraShakeDecays(t *testing.T) {
+	target := testTarget(1000, 500)
+	cam := newTestCamera(target, nil)
+	left, top := cam.Left, cam.Top
+
+	cam.AddShake(FIXED_ONE / 2)
+	cam.AddShake(FIXED_ONE)
+	if cam.Trauma != FIXED_ONE {
+		t.Fatalf("trauma went to %v, past a full shake", cam.Trauma.Float())
+	}
+
+	st := GameState{Rand: 1}
+	shook := false
+	last := cam.Trauma
+	for i := 0; i < 90; i++ {
+		cam.Update(&DEFAULT_CAMERA, target, nil, st.Random)
+		if cam.Trauma >= last {
+			t.Fatalf("step %d: trauma didn't go down", i)
+		}
+		if cam.Left != left || cam.Top != top {
+			shook = true
+		}
+		last = cam.Trauma
+	}
+	if !shook {
+		t.Error("view never shook")
+	}
+
+	// once it's worn off the view settles back where it was
+	stepCamera(cam, target, nil, 10)
+	if cam.Trauma != 0 || cam.Shake != (Vector{}) {
+		t.Errorf("still shaking %v with %v trauma", cam.Shake, cam.Trauma.Float())
+	}
+	if cam.Left != left || cam.Top != top {
+		t.Errorf("view at %d,%d after shaking, was at %d,%d", cam.Left, cam.Top, left, top)
+	}
+}
+
+// the debug zoom is only for drawing, so it mustn't change the camera the
+// game is played with
+func TestZoomStaysOutOfState(t *testing.T) {
+	s := newTestScene(t, testObject("player_start", 16, 32))
+	s.zoom = 3
+	stepScene(s, UserCommand{Right: 255}, 60)
+
+	cam := &s.state.Camera
+	if cam.Zoom != 1 || cam.Size != cam.Screen {
+		t.Errorf("camera in the state has zoom %d and size %v", cam.Zoom, cam.Size)
+	}
+}
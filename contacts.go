@@ -0,0 +1,150 @@
+package main
+
+import "sort"
+
+// collision groups. an entity is in the groups set in Entity.Group and touches
+// anything in a group set in its Entity.Mask
+const (
+	GROUP_PLAYER uint32 = 1 << iota
+	GROUP_ENEMY
+	GROUP_PICKUP
+	GROUP_SHOT
+)
+
+type ContactPhase int
+
+const (
+	CP_ENTER ContactPhase = iota // started touching this step
+	CP_STAY                      // still touching from last step
+	CP_EXIT                      // stopped touching, or one of them went away
+)
+
+// called once per touching pair per step, with a < b. on CP_EXIT either entity
+// may no longer be valid
+type ContactFunc func(a, b int, phase ContactPhase)
+
+// Broadphase finds overlapping entities by sweep and prune along x. entities
+// are kept sorted by their left edge between steps, and since nothing moves
+// far in one step the insertion sort that keeps them in order is close to
+// linear. it also remembers which pairs touched last step so it can tell
+// entering from staying from exiting.
+//
+// those pairs are kept by slot and live here rather than in the GameState, so
+// they aren't saved, sent or checksummed with it. anything that swaps the
+// state out for another one, like loading a save, has to start over with an
+// empty Broadphase, otherwise the old pairs get told about whatever is in
+// those slots now
+type Broadphase struct {
+	order  []int
+	listed []bool
+	pairs  []uint64
+	next   []uint64
+}
+
+func contactKey(a, b int) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return uint64(a)<<32 | uint64(b)
+}
+
+func contactPair(key uint64) (int, int) {
+	return int(key >> 32), int(key & 0xffffffff)
+}
+
+// true if either entity cares about the other
+func wantsContact(a, b *Entity) bool {
+	return a.Group&b.Mask != 0 || b.Group&a.Mask != 0
+}
+
+func overlaps(a, b *Entity) bool {
+	return a.Pos.X < b.Pos.X+Fx(int(b.Size.W)) && b.Pos.X < a.Pos.X+Fx(int(a.Size.W)) &&
+		a.Pos.Y < b.Pos.Y+Fx(int(b.Size.H)) && b.Pos.Y < a.Pos.Y+Fx(int(a.Size.H))
+}
+
+// find every touching pair among the valid entities and report them to fn,
+// along with pairs that stopped touching since the last call. live is the slots
+// in ents worth looking at. pairs are reported in a fixed order so the
+// simulation stays deterministic
+func (bp *Broadphase) Update(ents []Entity, live []uint16, fn ContactFunc) {
+	bp.sync(ents, live)
+
+	// insertion sort by left edge, cheap since the order rarely changes much
+	for i := 1; i < len(bp.order); i++ {
+		for j := i; j > 0 && ents[bp.order[j]].Pos.X < ents[bp.order[j-1]].Pos.X; j-- {
+			bp.order[j], bp.order[j-1] = bp.order[j-1], bp.order[j]
+		}
+	}
+
+	// sweep along x. once something starts to the right of a's right edge,
+	// so does everything after it
+	bp.next = bp.next[:0]
+	for i, ai := range bp.order {
+		a := &ents[ai]
+		if a.Group == 0 && a.Mask == 0 {
+			continue
+		}
+
+		right := a.Pos.X + Fx(int(a.Size.W))
+		for _, bi := range bp.order[i+1:] {
+			b := &ents[bi]
+			if b.Pos.X >= right {
+				break
+			}
+			if wantsContact(a, b) && overlaps(a, b) {
+				bp.next = append(bp.next, contactKey(ai, bi))
+			}
+		}
+	}
+	sort.Slice(bp.next, func(i, j int) bool { return bp.next[i] < bp.next[j] })
+
+	// both lists are sorted, so walk them together to find what changed
+	i, j := 0, 0
+	for i < len(bp.pairs) || j < len(bp.next) {
+		switch {
+		case j == len(bp.next) || (i < len(bp.pairs) && bp.pairs[i] < bp.next[j]):
+			a, b := contactPair(bp.pairs[i])
+			fn(a, b, CP_EXIT)
+			i++
+		case i == len(bp.pairs) || bp.next[j] < bp.pairs[i]:
+			a, b := contactPair(bp.next[j])
+			fn(a, b, CP_ENTER)
+			j++
+		default:
+			a, b := contactPair(bp.next[j])
+			fn(a, b, CP_STAY)
+			i++
+			j++
+		}
+	}
+
+	bp.pairs, bp.next = bp.next, bp.pairs
+}
+
+// make the sorted list hold exactly the valid entities, keeping the order of
+// the ones that were already in it
+func (bp *Broadphase) sync(ents []Entity, live []uint16) {
+	if len(bp.listed) != len(ents) {
+		bp.order = bp.order[:0]
+		bp.listed = make([]bool, len(ents))
+	}
+
+	n := 0
+	for _, i := range bp.order {
+		if ents[i].Valid {
+			bp.order[n] = i
+			n++
+		} else {
+			bp.listed[i] = false
+		}
+	}
+	bp.order = bp.order[:n]
+
+	for _, slot := range live {
+		i := int(slot)
+		if ents[i].Valid && !bp.listed[i] {
+			bp.order = append(bp.order, i)
+			bp.listed[i] = true
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+type contactEvent struct {
+	a, b  int
+	phase ContactPhase
+}
+
+func TestBroadphasePhases(t *testing.T) {
+	ents := make([]Entity, 4)
+	for i := 1; i < 4; i++ {
+		ents[i] = Entity{Valid: true, Size: Size{10, 10}, Group: GROUP_ENEMY, Mask: GROUP_PLAYER}
+	}
+	ents[1].Group, ents[1].Mask = GROUP_PLAYER, GROUP_ENEMY
+	ents[2].Pos = Vec2{Fx(5), Fx(5)}
+	ents[3].Pos = Vec2{Fx(100), 0}
+	live := []uint16{1, 2, 3}
+
+	var bp Broadphase
+	var got []contactEvent
+	record := func(a, b int, phase ContactPhase) {
+		got = append(got, contactEvent{a, b, phase})
+	}
+	step := func(want ...contactEvent) {
+		t.Helper()
+		got = got[:0]
+		bp.Update(ents, live, record)
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+
+	step(contactEvent{1, 2, CP_ENTER})
+	step(contactEvent{1, 2, CP_STAY})
+
+	// 3 comes over to 1 while 2 goes away
+	ents[2].Pos.X = Fx(50)
+	ents[3].Pos.X = Fx(-5)
+	step(contactEvent{1, 2, CP_EXIT}, contactEvent{1, 3, CP_ENTER})
+
+	// despawning is an exit too
+	ents[3].Valid = false
+	step(contactEvent{1, 3, CP_EXIT})
+
+	// two enemies don't care about each other
+	ents[3] = Entity{Valid: true, Pos: ents[2].Pos, Size: Size{10, 10}, Group: GROUP_ENEMY, Mask: GROUP_PLAYER}
+	step()
+}
+
+// entities spread over a level sized area, wandering a little each step
+func benchmarkBroadphase(b *testing.B, n int) {
+	r := rand.New(rand.NewSource(1))
+	ents := make([]Entity, n+1)
+	live := make([]uint16, 0, n)
+	side := 40 * n // keeps roughly the same crowding at any count
+	for i := 1; i <= n; i++ {
+		ent := &ents[i]
+		ent.Valid = true
+		ent.Pos = Vec2{Fx(r.Intn(side)), Fx(r.Intn(480))}
+		ent.Vel = Vec2{Fx(r.Intn(5) - 2), 0}
+		ent.Size = Size{16, 16}
+		if i%8 == 0 {
+			ent.Group, ent.Mask = GROUP_PLAYER, GROUP_ENEMY|GROUP_PICKUP
+		} else {
+			ent.Group, ent.Mask = GROUP_ENEMY, GROUP_PLAYER
+		}
+		live = append(live, uint16(i))
+	}
+
+	var bp Broadphase
+	touches := 0
+	count := func(a, b int, phase ContactPhase) { touches++ }
+	bp.Update(ents, live, count)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 1; j <= n; j++ {
+			ents[j].Pos.X += ents[j].Vel.X
+		}
+		bp.Update(ents, live, count)
+	}
+}
+
+// an EntityList only holds MAX_ENTITIES, so these call Update directly
+func BenchmarkBroadphase1k(b *testing.B)  { benchmarkBroadphase(b, 1000) }
+func BenchmarkBroadphase10k(b *testing.B) { benchmarkBroadphase(b, 10000) }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"reflect"
+)
+
+// a delta is everything that changed between two GameStates, down to single
+// fields, packed into as few bits as it takes. it's written by walking both
+// states side by side:
+//
+//	struct  a bit per field, set if it changed, then each changed field
+//	array   how many elements changed, then for each one how far it is past
+//	        the last one and what changed in it
+//	bool    nothing, it changed so it flipped
+//	number  the difference, zigzagged so small changes either way are short
+//
+// numbers go as a 7 bit length and then that many bits without the top one,
+// which is always set. most steps only move a few entities a few pixels, so a
+// delta is usually a few dozen bytes. applying one to anything other than the
+// state it was made from gives nonsense
+//
+// GameState has to stay made of structs, arrays, bools and integers for this
+// to work, which it is anyway so it can be copied around
+
+var ErrBadDelta = errors.New("delta doesn't fit the state")
+
+// the delta that turns from into to
+func EncodeDelta(from, to *GameState) []byte {
+	var w bitWriter
+	encodeValue(&w, reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem())
+	return w.buf
+}
+
+// turn st into the state a delta was made to, if st is the state it was made
+// from. an error leaves st half changed, or changed into something that
+// doesn't make sense. animations aren't checked, there are no sprites here
+func ApplyDelta(st *GameState, delta []byte) error {
+	if err := applyDelta(st, delta); err != nil {
+		return err
+	}
+	return st.Validate(nil)
+}
+
+// ApplyDelta without checking what comes out
+func applyDelta(st *GameState, delta []byte) error {
+	r := bitReader{buf: delta}
+	applyValue(&r, reflect.ValueOf(st).Elem())
+	if r.err != nil {
+		return r.err
+	}
+	if r.pos+7 < len(delta)*8 {
+		return ErrBadDelta
+	}
+	return nil
+}
+
+func encodeValue(w *bitWriter, from, to reflect.Value) {
+	switch to.Kind() {
+	case reflect.Struct:
+		n := to.NumField()
+		changed := make([]bool, n)
+		for i := 0; i < n; i++ {
+			changed[i] = !sameValue(from.Field(i), to.Field(i))
+			w.writeBit(changed[i])
+		}
+		for i := 0; i < n; i++ {
+			if changed[i] {
+				encodeValue(w, from.Field(i), to.Field(i))
+			}
+		}
+
+	case reflect.Array:
+		var changed []int
+		for i := 0; i < to.Len(); i++ {
+			if !sameValue(from.Index(i), to.Index(i)) {
+				changed = append(changed, i)
+			}
+		}
+		w.writeVar(uint64(len(changed)))
+		last := -1
+		for _, i := range changed {
+			w.writeVar(uint64(i - last - 1))
+			encodeValue(w, from.Index(i), to.Index(i))
+			last = i
+		}
+
+	case reflect.Bool:
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeVar(zigzag(to.Int() - from.Int()))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		w.writeVar(zigzag(int64(to.Uint() - from.Uint())))
+
+	default:
+		panic("can't delta a " + to.Type().String())
+	}
+}
+
+func applyValue(r *bitReader, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		n := v.NumField()
+		changed := make([]bool, n)
+		for i := 0; i < n; i++ {
+			changed[i] = r.readBit()
+		}
+		for i := 0; i < n && r.err == nil; i++ {
+			if changed[i] {
+				applyValue(r, v.Field(i))
+			}
+		}
+
+	case reflect.Array:
+		n := r.readVar()
+		i := -1
+		for ; n > 0 && r.err == nil; n-- {
+			gap := r.readVar()
+			if gap >= uint64(v.Len()-i-1) {
+				r.fail()
+				return
+			}
+			i += int(gap) + 1
+			applyValue(r, v.Index(i))
+		}
+
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + unzigzag(r.readVar()))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + uint64(unzigzag(r.readVar())))
+	}
+}
+
+// like ==, without the copying that comparing through Interface does
+func sameValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() == b.Uint()
+	}
+	panic("can't delta a " + a.Type().String())
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+type bitWriter struct {
+	buf []byte
+	pos int // in bits
+}
+
+func (w *bitWriter) writeBit(b bool) {
+	if w.pos%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if b {
+		w.buf[w.pos/8] |= 1 << uint(w.pos%8)
+	}
+	w.pos++
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := 0; i < n; i++ {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+func (w *bitWriter) writeVar(v uint64) {
+	n := bits.Len64(v)
+	w.writeBits(uint64(n), 7)
+	if n > 1 {
+		w.writeBits(v, n-1)
+	}
+}
+
+// reads past the end give zeros and set err
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) fail() {
+	if r.err == nil {
+		r.err = ErrBadDelta
+	}
+}
+
+func (r *bitReader) readBit() bool {
+	if r.pos >= len(r.buf)*8 {
+		r.fail()
+		return false
+	}
+	b := r.buf[r.pos/8]&(1<<uint(r.pos%8)) != 0
+	r.pos++
+	return b
+}
+
+func (r *bitReader) readBits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.readBit() {
+			v |= 1 << uint(i)
+		}
+	}
+	return v
+}
+
+func (r *bitReader) readVar() uint64 {
+	n := int(r.readBits(7))
+	switch {
+	case n > 64:
+		r.fail()
+		return 0
+	case n == 0:
+		return 0
+	}
+	return r.readBits(n-1) | 1<<uint(n-1)
+}
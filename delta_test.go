@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// fills values from the front of a byte slice, with zeros once it runs out
+type fuzzFiller struct {
+	data []byte
+}
+
+func (f *fuzzFiller) next(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if len(f.data) > 0 {
+			v |= uint64(f.data[0]) << uint(8*i)
+			f.data = f.data[1:]
+		}
+	}
+	return v
+}
+
+// every number in v takes as many bytes as it's wide, bools take one
+func (f *fuzzFiller) fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f.fill(v.Field(i))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			f.fill(v.Index(i))
+		}
+	case reflect.Bool:
+		v.SetBool(f.next(1)&1 != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(f.next(int(v.Type().Size()))))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(f.next(int(v.Type().Size())))
+	}
+}
+
+// a state made up from fuzz input. filling the whole entity list would take
+// more bytes than the fuzzer likes to make, so after the rest of the state it
+// goes a slot at a time, each one picked by the input
+func fuzzState(data []byte) GameState {
+	var st GameState
+	f := &fuzzFiller{data}
+	f.fill(reflect.ValueOf(&st.Tick).Elem())
+	f.fill(reflect.ValueOf(&st.Rand).Elem())
+	f.fill(reflect.ValueOf(&st.LocalEnt).Elem())
+	f.fill(reflect.ValueOf(&st.Camera).Elem())
+
+	ents := &st.Entities
+	f.fill(reflect.ValueOf(&ents.NumLive).Elem())
+	f.fill(reflect.ValueOf(&ents.NumFree).Elem())
+	f.fill(reflect.ValueOf(&ents.Used).Elem())
+	for len(f.data) > 0 {
+		what := f.next(1)
+		slot := f.next(2) % MAX_ENTITIES
+		switch what % 4 {
+		case 0:
+			f.fill(reflect.ValueOf(&ents.Ents[slot]).Elem())
+		case 1:
+			ents.Gens[slot] = uint16(f.next(2))
+		case 2:
+			ents.Live[slot] = uint16(f.next(2))
+		case 3:
+			ents.Free[slot] = uint16(f.next(2))
+		}
+	}
+	return st
+}
+
+// states from the fuzzer are mostly nonsense, so this goes around ApplyDelta's
+// checks to see the encoding works, then makes sure the checks agree with Validate
+func deltaRoundTrip(t *testing.T, from, to *GameState) {
+	delta := EncodeDelta(from, to)
+	st := *from
+	if err := applyDelta(&st, delta); err != nil {
+		t.Fatalf("applying a %d byte delta: %s", len(delta), err)
+	}
+	if st != *to {
+		t.Fatal("state came out different from the one the delta was made to")
+	}
+
+	st = *from
+	if err, want := ApplyDelta(&st, delta), to.Validate(nil); (err == nil) != (want == nil) {
+		t.Fatalf("applying a delta to a state gave %v, checking the state gave %v", err, want)
+	}
+}
+
+func TestDeltaSteps(t *testing.T) {
+	s := newTestScene(t,
+		testObject("player_start", 16, 32),
+		testObject("EntityYorp", 200, 24),
+	)
+	s.player().Inv.Ammo = 3
+
+	// from nothing, then a step at a time like a server does
+	prev := GameState{}
+	for i := 0; i < 300; i++ {
+		s.update(scriptedCommand(i))
+		deltaRoundTrip(t, &prev, &s.state)
+		prev = s.state
+	}
+	if n := len(EncodeDelta(&prev, &prev)); n > 1 {
+		t.Errorf("delta between the same state is %d bytes", n)
+	}
+}
+
+// each run walks the whole state a few times, which is slow for a fuzz
+// target, so give it something like -fuzzminimizetime 2s or it spends most of
+// its time shrinking inputs
+func FuzzDelta(f *testing.F) {
+	f.Add([]byte{}, []byte{1, 2, 3, 4})
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 0, 0}, []byte{2, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff})
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 4; i++ {
+		a, b := make([]byte, 400), make([]byte, 400)
+		rnd.Read(a)
+		rnd.Read(b)
+		f.Add(a, b)
+	}
+
+	// a real game as a delta from nothing, so something gets through the
+	// checks to be stepped
+	s := newTestScene(f, testObject("player_start", 16, 32), testObject("EntityYorp", 200, 24))
+	f.Add([]byte{}, EncodeDelta(&GameState{}, &s.state))
+
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		from, to := fuzzState(a), fuzzState(b)
+		deltaRoundTrip(t, &from, &to)
+
+		// anything at all as a delta either works or errors, onto an empty
+		// state or a full one. whatever it lets through has to be safe to
+		// step and checksum
+		for _, st := range []GameState{{}, from} {
+			if ApplyDelta(&st, b) != nil {
+				continue
+			}
+			s := newTestScene(t)
+			s.state = st
+			s.state.Checksum()
+			s.update(UserCommand{})
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Scaling is how the virtual screen is blown up to fill the window
+type Scaling int
+
+const (
+	// scale by whole pixels only, and give the virtual screen whatever size
+	// fills the window at that scale so more or less of the world shows
+	SCALE_INTEGER Scaling = iota
+	// keep the virtual screen the size it is and scale it as far as it goes,
+	// with black bars where the window is a different shape
+	SCALE_LETTERBOX
+)
+
+var scalingNames = map[string]Scaling{
+	"integer":   SCALE_INTEGER,
+	"letterbox": SCALE_LETTERBOX,
+}
+
+// Engine is everything that lives on the main thread: the renderer and the
+// resources uploaded to it, and the scenes feeding it command lists. scenes
+// draw to a virtual screen in its own pixels, and the renderer scales that up
+// to the window
+type Engine struct {
+	renderer *sdl.Renderer
+	textures *TextureManager
+	loader   *ImageLoader
+	scenes   *SceneManager
+	pads     Gamepads
+	target   *sdl.Texture // offscreen target the incoming scene draws to during crossfades
+	virtual  Size         // the virtual screen asked for
+	scaling  Scaling
+	width    int32 // the virtual screen as it is now
+	height   int32
+}
+
+func NewEngine(renderer *sdl.Renderer, winWidth, winHeight int32, virtual Size, scaling Scaling) (*Engine, error) {
+	textures, err := NewTextureManager(renderer)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		renderer: renderer,
+		textures: textures,
+		loader:   NewImageLoader(textures),
+		scenes:   NewSceneManager(),
+		virtual:  virtual,
+		scaling:  scaling,
+	}
+
+	if err := e.Resize(winWidth, winHeight); err != nil {
+		e.loader.Close()
+		textures.Destroy()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// work out the virtual screen for a window size and tell the scenes about it
+func (e *Engine) Resize(winWidth, winHeight int32) error {
+	w, h := e.virtual.W, e.virtual.H
+	if e.scaling == SCALE_INTEGER {
+		scale := winWidth / w
+		if s := winHeight / h; s < scale {
+			scale = s
+		}
+		if scale < 1 {
+			scale = 1
+		}
+		w, h = winWidth/scale, winHeight/scale
+	}
+
+	if e.target != nil && w == e.width && h == e.height {
+		return nil
+	}
+
+	if err := e.renderer.SetLogicalSize(int(w), int(h)); err != nil {
+		return err
+	}
+	if err := e.renderer.SetIntegerScale(e.scaling == SCALE_INTEGER); err != nil {
+		return err
+	}
+
+	// crossfades draw a whole scene to the target, so it has to match
+	target, err := e.renderer.CreateTexture(sdl.PIXELFORMAT_RGBA8888, sdl.TEXTUREACCESS_TARGET, int(w), int(h))
+	if err != nil {
+		return err
+	}
+	target.SetBlendMode(sdl.BLENDMODE_BLEND)
+	if e.target != nil {
+		e.target.Destroy()
+	}
+	e.target = target
+
+	e.width, e.height = w, h
+	e.scenes.Resize(Size{w, h})
+	return nil
+}
+
+func (e *Engine) Destroy() {
+	e.pads.Close()
+	e.loader.Close()
+	e.target.Destroy()
+	e.textures.Destroy()
+}
+
+// process engine commands and errors from every running scene. scenes should
+// block on waiting for the engine to return. if you want an engine function
+// that doesn't block, just send a response back on the channel immediately to
+// ensure that all calls from the scene can take the same procedure
+func (e *Engine) serviceScenes() error {
+	e.scenes.reap()
+
+	for _, se := range e.scenes.running {
+	commands:
+		for {
+			select {
+			case engCmd = <-se.ch.Eng:
+				se.ch.Eng <- e.handleCommand(engCmd)
+			default:
+				break commands
+			}
+		}
+
+	errors:
+		for {
+			select {
+			case err := <-se.ch.Err: // we have an error from the gamestate
+				// assets that failed to load have already been replaced with the
+				// missing texture, so those are only worth a warning
+				if _, ok := err.(*AssetError); ok {
+					fmt.Printf("Warning: %s\n", err)
+					continue
+				}
+				return err
+			default:
+				break errors
+			}
+		}
+	}
+
+	// upload anything the loader workers have finished decoding
+	e.loader.Update()
+
+	return nil
+}
+
+// ask every scene to quit and wait for them to finish unloading, answering
+// their engine commands in the meantime so they can hand back resources
+func (e *Engine) Shutdown(timeout time.Duration) error {
+	e.scenes.StopAll()
+
+	deadline := time.Now().Add(timeout)
+	for e.scenes.Running() > 0 {
+		if time.Now().After(deadline) {
+			return ErrShutdownTimeout
+		}
+
+		// scenes can still fail on the way out, but there's nothing left to stop
+		if err := e.serviceScenes(); err != nil {
+			fmt.Printf("Scene failed: %s\n", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return nil
+}
+
+func (e *Engine) handleCommand(cmd EngineCommand) EngineCommand {
+	switch cmd.Id {
+	// load an image from disk and upload to gpu. on failure the scene
+	// still gets an image back, the missing texture
+	case EC_LOADIMAGE:
+		image, err := e.textures.Load(cmd.Data.(string))
+		return EngineCommand{Id: cmd.Id, Success: err == nil, Data: image, Err: err}
+
+	// drop a reference to a previously loaded image
+	case EC_RELEASEIMAGE:
+		err := e.textures.Release(cmd.Data.(int))
+		return EngineCommand{Id: cmd.Id, Success: err == nil, Err: err}
+
+	// start loading a set of images in the background. the scene gets
+	// a handle back straight away and can wait on it or poll progress
+	case EC_LOADIMAGES:
+		batch := e.loader.Queue(cmd.Data.([]string))
+		return EngineCommand{Id: cmd.Id, Success: true, Data: batch}
+
+	// scene stack changes. these take effect immediately, the scene that asked
+	// might be told to quit before it gets the reply
+	case EC_PUSHSCENE:
+		req := cmd.Data.(SceneRequest)
+		e.scenes.Push(req.Scene, req.Transition)
+		return EngineCommand{Id: cmd.Id, Success: true}
+
+	case EC_POPSCENE:
+		e.scenes.Pop(cmd.Data.(Transition))
+		return EngineCommand{Id: cmd.Id, Success: true}
+
+	case EC_REPLACESCENE:
+		req := cmd.Data.(SceneRequest)
+		e.scenes.Replace(req.Scene, req.Transition)
+		return EngineCommand{Id: cmd.Id, Success: true}
+
+	// shake a gamepad, or all of them. pads that can't rumble are left alone
+	case EC_RUMBLE:
+		e.pads.Rumble(cmd.Data.(Rumble))
+		return EngineCommand{Id: cmd.Id, Success: true}
+	}
+
+	return EngineCommand{Id: cmd.Id, Success: false, Err: ErrUnknownCommand}
+}
+
+// draw whatever scenes are visible, including any transition between them
+func (e *Engine) draw(now time.Time) {
+	e.renderer.SetDrawColor(0, 0, 0, 255)
+	e.renderer.Clear()
+
+	sm := e.scenes
+	if sm.trans == TR_NONE {
+		e.drawScene(sm.Top())
+		e.renderer.Present()
+		return
+	}
+
+	// hold the outgoing scene on screen until the incoming one has loaded
+	if sm.transTo != nil && !sm.transTo.scene.Ready() {
+		e.drawScene(sm.transFrom)
+		e.drawLoadingScreen()
+		e.renderer.Present()
+		return
+	}
+
+	t := sm.transitionProgress(now)
+	if t >= 1 {
+		sm.endTransition()
+		e.drawScene(sm.Top())
+		e.renderer.Present()
+		return
+	}
+
+	switch sm.trans {
+	// fade the outgoing scene to black, then the incoming one back in
+	case TR_FADE:
+		if t < 0.5 {
+			e.drawScene(sm.transFrom)
+			e.drawOverlay(uint8(255 * t * 2))
+		} else {
+			e.drawScene(sm.transTo)
+			e.drawOverlay(uint8(255 * (1 - t) * 2))
+		}
+
+	// draw the incoming scene offscreen and blend it over the outgoing one
+	case TR_CROSSFADE:
+		e.drawScene(sm.transFrom)
+		e.renderer.SetRenderTarget(e.target)
+		e.renderer.SetDrawColor(0, 0, 0, 0)
+		e.renderer.Clear()
+		e.drawScene(sm.transTo)
+		e.renderer.SetRenderTarget(nil)
+		e.target.SetAlphaMod(uint8(255 * t))
+		e.renderer.Copy(e.target, nil, nil)
+	}
+
+	e.renderer.Present()
+}
+
+// draw a single scene, or its loading screen if it isn't ready yet
+func (e *Engine) drawScene(se *sceneEntry) {
+	if se == nil {
+		return
+	}
+
+	if !se.scene.Ready() {
+		e.drawLoadingScreen()
+		return
+	}
+
+	e.drawCommands(se.scene.Render())
+}
+
+func (e *Engine) drawCommands(rcmds *RenderCommandList) {
+	for i := 0; i < int(rcmds.NumCommands); i++ {
+		rc = &rcmds.Commands[i]
+
+		switch rc.Id {
+		case RC_PIC:
+			if rc.ImgSize.W > 0 && rc.ImgSize.H > 0 {
+				srcRect = sdl.Rect{rc.ImgPos.X, rc.ImgPos.Y, rc.ImgSize.W, rc.ImgSize.H}
+			}
+			dstRect = sdl.Rect{rc.Pos.X, rc.Pos.Y, rc.Size.W, rc.Size.H}
+			if rc.Flip {
+				e.renderer.CopyEx(e.textures.Get(rc.ImageId), &srcRect, &dstRect, 0, nil, sdl.FLIP_HORIZONTAL)
+			} else {
+				e.renderer.Copy(e.textures.Get(rc.ImageId), &srcRect, &dstRect)
+			}
+		case RC_RECT:
+			e.renderer.SetDrawColor(rc.BackColor.R, rc.BackColor.G, rc.BackColor.B, rc.BackColor.A)
+			dstRect = sdl.Rect{rc.Pos.X, rc.Pos.Y, rc.Size.W, rc.Size.H}
+			e.renderer.FillRect(&dstRect)
+			e.renderer.SetDrawColor(0, 0, 0, 255)
+		}
+	}
+}
+
+// cover the screen in translucent black
+func (e *Engine) drawOverlay(alpha uint8) {
+	e.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	e.renderer.SetDrawColor(0, 0, 0, alpha)
+	e.renderer.FillRect(&sdl.Rect{X: 0, Y: 0, W: e.width, H: e.height})
+	e.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+	e.renderer.SetDrawColor(0, 0, 0, 255)
+}
+
+// draw a progress bar for whatever the loader is working on
+func (e *Engine) drawLoadingScreen() {
+	loaded, total := e.loader.Progress()
+	if total == 0 {
+		return
+	}
+
+	bar := sdl.Rect{X: e.width / 4, Y: e.height/2 - 8, W: e.width / 2, H: 16}
+	e.renderer.SetDrawColor(64, 64, 64, 255)
+	e.renderer.FillRect(&bar)
+
+	bar.W = bar.W * int32(loaded) / int32(total)
+	e.renderer.SetDrawColor(168, 168, 168, 255)
+	e.renderer.FillRect(&bar)
+
+	e.renderer.SetDrawColor(0, 0, 0, 255)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const MAX_ENTITIES = 1024
+
+// EntityId names an entity for as long as it lives. the low 16 bits are its
+// slot and the high 16 bits count how many times that slot has been used, so
+// an id held onto after its entity despawns won't find whatever took the slot
+type EntityId uint32
+
+const NO_ENTITY EntityId = 0
+
+func (id EntityId) slot() int {
+	return int(id & 0xffff)
+}
+
+type EntityType uint16
+
+const (
+	ET_NONE EntityType = iota
+	ET_PLAYER
+	ET_SHOT
+	ET_YORP
+	ET_PATPAT
+	ET_COLLECTABLE
+	ET_RAYGUN
+	ET_POGO
+	NUM_ENTITY_TYPES
+)
+
+// World is what entity behaviour gets to see during a step
+type World struct {
+	State     *GameState
+	Collision *CollisionMap
+	Sprites   *SpriteSet
+	Step      time.Duration // simulated time per step
+	Cmd       UserCommand
+	Cmds      map[EntityId]UserCommand // each player's command when there's more than one, like on a server
+	Rumbles   []Rumble                 // asked for since the scene last sent them to the gamepads
+}
+
+// the command a player entity is following this step. with only one player
+// that's always Cmd, otherwise it's whatever its client sent
+func (w *World) Command(ent *Entity) UserCommand {
+	if w.Cmds != nil {
+		return w.Cmds[ent.Id]
+	}
+	return w.Cmd
+}
+
+// shake the gamepads if ent is the player being controlled here. this is only
+// feedback, it doesn't change the state
+func (w *World) Rumble(ent *Entity, strength float32, length time.Duration) {
+	if ent.Id == w.State.LocalEnt {
+		w.Rumbles = append(w.Rumbles, Rumble{Pad: -1, Strength: strength, Length: length})
+	}
+}
+
+// EntityClass is the behaviour shared by every entity of a type. it lives
+// outside of GameState so the state stays plain data. either function can be nil
+type EntityClass struct {
+	Name     string
+	Think    func(w *World, ent *Entity)                                    // once per step
+	Touch    func(w *World, ent *Entity, other *Entity, phase ContactPhase) // see Broadphase
+	Variants int                                                            // kinds of it by Entity.Variant, if there's more than one
+}
+
+var entityClasses [NUM_ENTITY_TYPES]EntityClass
+
+// SpawnFunc makes an entity from a map object, or returns nil if it can't
+type SpawnFunc func(w *World, obj *SpawnObject) *Entity
+
+var spawnFuncs = make(map[string]SpawnFunc)
+
+var ErrUnknownEntity = errors.New("unknown entity type")
+
+// make objects of a type spawn with fn. the type is the object type in Tiled
+// maps and the entity class name in Impact levels, so one function can be
+// registered under several names
+func RegisterSpawn(typ string, fn SpawnFunc) {
+	spawnFuncs[typ] = fn
+}
+
+// spawn an entity for every object with a registered type. objects without a
+// type are only scenery and are skipped quietly. anything else that can't be
+// spawned comes back as an error, once per type
+func SpawnObjects(w *World, objs []SpawnObject) []error {
+	var errs []error
+	var unknown []string
+	counts := make(map[string]int)
+	failed := 0
+
+	for i := range objs {
+		obj := &objs[i]
+		if obj.Type == "" {
+			continue
+		}
+
+		fn, ok := spawnFuncs[obj.Type]
+		if !ok {
+			if counts[obj.Type] == 0 {
+				unknown = append(unknown, obj.Type)
+			}
+			counts[obj.Type]++
+			continue
+		}
+		if fn(w, obj) == nil {
+			failed++
+		}
+	}
+
+	for _, typ := range unknown {
+		errs = append(errs, fmt.Errorf("%w %q (%d objects)", ErrUnknownEntity, typ, counts[typ]))
+	}
+	if failed > 0 {
+		errs = append(errs, fmt.Errorf("couldn't spawn %d objects", failed))
+	}
+	return errs
+}
+
+// EntityList holds every entity in a GameState. it is all fixed size arrays so
+// copying a GameState copies the entities with it. Live lists the slots in use
+// in the order they were spawned so only those need to be looked at
+type EntityList struct {
+	Ents    [MAX_ENTITIES]Entity
+	Gens    [MAX_ENTITIES]uint16
+	Live    [MAX_ENTITIES]uint16
+	NumLive int32
+	Free    [MAX_ENTITIES]uint16
+	NumFree int32
+	Used    int32 // slots that have ever been handed out, the rest are free too
+}
+
+// slots of the entities that are alive. anything spawned while looping over
+// this won't be in it, and anything despawned is still in it but not Valid
+func (l *EntityList) LiveSlots() []uint16 {
+	return l.Live[:l.NumLive]
+}
+
+// make a new entity of a type. returns nil if there's no room
+func (l *EntityList) Spawn(typ EntityType) *Entity {
+	var slot int
+	if l.NumFree > 0 {
+		l.NumFree--
+		slot = int(l.Free[l.NumFree])
+	} else if l.Used < MAX_ENTITIES-1 {
+		// slot 0 is never used so that NO_ENTITY never names anything
+		l.Used++
+		slot = int(l.Used)
+	} else {
+		return nil
+	}
+
+	l.Gens[slot]++
+	l.Live[l.NumLive] = uint16(slot)
+	l.NumLive++
+
+	ent := &l.Ents[slot]
+	*ent = Entity{Valid: true, Id: EntityId(l.Gens[slot])<<16 | EntityId(slot), Type: typ}
+	return ent
+}
+
+// remove an entity. it stops being Valid straight away, but its slot isn't
+// reused until the next Compact
+func (l *EntityList) Despawn(id EntityId) {
+	if ent := l.Get(id); ent != nil {
+		ent.Valid = false
+	}
+}
+
+// look up an entity, or nil if it has despawned
+func (l *EntityList) Get(id EntityId) *Entity {
+	if id == NO_ENTITY {
+		return nil
+	}
+
+	ent := &l.Ents[id.slot()]
+	if !ent.Valid || ent.Id != id {
+		return nil
+	}
+	return ent
+}
+
+// drop everything that has despawned from Live and free up their slots
+func (l *EntityList) Compact() {
+	n := int32(0)
+	for _, slot := range l.LiveSlots() {
+		if l.Ents[slot].Valid {
+			l.Live[n] = slot
+			n++
+		} else {
+			l.Free[l.NumFree] = slot
+			l.NumFree++
+		}
+	}
+	l.NumLive = n
+}
+
+// check a state that came from outside the simulation, like a save or a
+// snapshot from a server, for anything that would send a step or a draw off
+// the end of something. animations are only checked when there are sprites
+// to check them against, without any nothing gets drawn or animated anyway
+func (st *GameState) Validate(sprites *SpriteSet) error {
+	l := &st.Entities
+	if l.Used < 0 || l.Used >= MAX_ENTITIES || l.NumLive < 0 || l.NumFree < 0 || l.NumLive+l.NumFree > l.Used {
+		return errors.New("entity slots don't add up")
+	}
+	if st.LocalEnt.slot() >= MAX_ENTITIES {
+		return fmt.Errorf("local entity %x is out of range", st.LocalEnt)
+	}
+
+	// every slot is live or free at most once, and only once it's been used
+	var seen [MAX_ENTITIES]bool
+	for _, slot := range l.LiveSlots() {
+		if slot == 0 || int32(slot) > l.Used || seen[slot] {
+			return fmt.Errorf("slot %d can't be live", slot)
+		}
+		seen[slot] = true
+	}
+	for _, slot := range l.Free[:l.NumFree] {
+		if slot == 0 || int32(slot) > l.Used || seen[slot] || l.Ents[slot].Valid {
+			return fmt.Errorf("slot %d can't be free", slot)
+		}
+		seen[slot] = true
+	}
+
+	// Get finds a valid entity in any slot, and despawned ones are still
+	// touched until they're compacted, so every slot is checked
+	for i := range l.Ents {
+		ent := &l.Ents[i]
+		if ent.Valid && ent.Id.slot() != i {
+			return fmt.Errorf("entity %x is in slot %d", ent.Id, i)
+		}
+		if ent.Type >= NUM_ENTITY_TYPES {
+			return fmt.Errorf("entity %x has unknown type %d", ent.Id, ent.Type)
+		}
+		if int(ent.Variant) >= max(1, entityClasses[ent.Type].Variants) {
+			return fmt.Errorf("entity %x has unknown variant %d", ent.Id, ent.Variant)
+		}
+		if sprites != nil && ent.Anim.Sheet != 0 && sprites.anim(&ent.Anim) == nil {
+			return fmt.Errorf("entity %x has no animation %d:%d frame %d", ent.Id, ent.Anim.Sheet, ent.Anim.Anim, ent.Anim.Frame)
+		}
+	}
+	return nil
+}
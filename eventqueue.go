@@ -0,0 +1,112 @@
+package main
+
+import "sync"
+
+const EVENT_QUEUE_SIZE = 256
+
+// EventQueue carries input from the engine thread to a scene. pushing never
+// blocks, so a scene that stalls can't hold up the window. when the queue is
+// full new events are dropped and counted instead, and the scene can find out
+// how many it missed. mouse motion and stick movement come in much faster
+// than anything reads them, so those replace the last event in the queue when
+// it was the same kind of thing, since only the latest position matters.
+// screen size and gamepad changes are never dropped or replaced, a scene that
+// missed one would be wrong about them until the next one, which may be never
+type EventQueue struct {
+	mu      sync.Mutex
+	buf     [EVENT_QUEUE_SIZE]Event
+	head    int
+	count   int
+	dropped int
+}
+
+func NewEventQueue() *EventQueue {
+	return &EventQueue{}
+}
+
+// add an event. returns false if it was dropped
+func (q *EventQueue) Push(ev Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count > 0 {
+		last := &q.buf[(q.head+q.count-1)%EVENT_QUEUE_SIZE]
+		if coalesces(last, &ev) {
+			*last = ev
+			return true
+		}
+	}
+
+	if q.count == EVENT_QUEUE_SIZE {
+		q.dropped++
+		if !mustKeep(ev.Type) {
+			return false
+		}
+		q.evict()
+	}
+
+	q.buf[(q.head+q.count)%EVENT_QUEUE_SIZE] = ev
+	q.count++
+	return true
+}
+
+// events that say how things are from now on, rather than something that
+// happened once
+func mustKeep(t EventType) bool {
+	return t == EV_RESIZE || t == EV_PADADDED || t == EV_PADREMOVED
+}
+
+// make room in a full queue by taking out the oldest event that can be
+// missed, moving everything after it up. if there somehow isn't one the
+// oldest goes
+func (q *EventQueue) evict() {
+	n := 0
+	for n < q.count && mustKeep(q.buf[(q.head+n)%EVENT_QUEUE_SIZE].Type) {
+		n++
+	}
+	if n == q.count {
+		n = 0
+	}
+	for ; n < q.count-1; n++ {
+		q.buf[(q.head+n)%EVENT_QUEUE_SIZE] = q.buf[(q.head+n+1)%EVENT_QUEUE_SIZE]
+	}
+	q.count--
+}
+
+// true if next can stand in for last
+func coalesces(last, next *Event) bool {
+	if last.Type != next.Type {
+		return false
+	}
+	switch next.Type {
+	case EV_MOUSEMOVE:
+		return true
+	case EV_PADAXIS:
+		return last.Pad == next.Pad && last.EvData1 == next.EvData1
+	}
+	return false
+}
+
+// take the oldest event off the queue. ok is false once it's empty
+func (q *EventQueue) Pop() (ev Event, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == 0 {
+		return Event{}, false
+	}
+	ev = q.buf[q.head]
+	q.head = (q.head + 1) % EVENT_QUEUE_SIZE
+	q.count--
+	return ev, true
+}
+
+// how many events were dropped since the last time this was asked
+func (q *EventQueue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := q.dropped
+	q.dropped = 0
+	return n
+}
@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func fillQueue(q *EventQueue) {
+	for i := 0; i < EVENT_QUEUE_SIZE; i++ {
+		q.Push(Event{Type: EV_KEY, Down: i%2 == 0, EvData1: i})
+	}
+}
+
+func TestEventQueueDropsWhenFull(t *testing.T) {
+	q := NewEventQueue()
+	fillQueue(q)
+	if q.Push(Event{Type: EV_KEY, EvData1: 1000}) {
+		t.Fatal("pushed onto a full queue")
+	}
+	if n := q.Dropped(); n != 1 {
+		t.Fatalf("dropped %d, want 1", n)
+	}
+	if n := q.Dropped(); n != 0 {
+		t.Fatalf("dropped count wasn't reset, got %d", n)
+	}
+
+	// what was already there comes out in order
+	for i := 0; i < EVENT_QUEUE_SIZE; i++ {
+		ev, ok := q.Pop()
+		if !ok || ev.EvData1 != i {
+			t.Fatalf("event %d came out as %+v", i, ev)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatal("queue not empty")
+	}
+}
+
+func TestEventQueueKeepsResizes(t *testing.T) {
+	q := NewEventQueue()
+	fillQueue(q)
+
+	// these push out the oldest key events instead of being lost
+	keep := []Event{
+		{Type: EV_RESIZE, EvData1: 640, EvData2: 480},
+		{Type: EV_PADREMOVED, Pad: 1},
+		{Type: EV_RESIZE, EvData1: 800, EvData2: 600},
+		{Type: EV_PADADDED, Pad: 2},
+	}
+	for _, ev := range keep {
+		if !q.Push(ev) {
+			t.Fatalf("%+v was dropped", ev)
+		}
+	}
+	if n := q.Dropped(); n != len(keep) {
+		t.Errorf("dropped %d, want %d", n, len(keep))
+	}
+
+	var got []Event
+	first := -1
+	for {
+		ev, ok := q.Pop()
+		if !ok {
+			break
+		}
+		if ev.Type == EV_KEY {
+			if first < 0 {
+				first = ev.EvData1
+			}
+			continue
+		}
+		got = append(got, ev)
+	}
+	if first != len(keep) {
+		t.Errorf("first key event left was %d, want %d", first, len(keep))
+	}
+	if len(got) != len(keep) {
+		t.Fatalf("got %d of the %d events that can't be dropped", len(got), len(keep))
+	}
+	for i := range keep {
+		if got[i] != keep[i] {
+			t.Errorf("event %d is %+v, want %+v", i, got[i], keep[i])
+		}
+	}
+}
+
+// once the queue is nothing but events that can't be dropped, the oldest goes
+func TestEventQueueAllKept(t *testing.T) {
+	q := NewEventQueue()
+	for i := 0; i < EVENT_QUEUE_SIZE+1; i++ {
+		q.Push(Event{Type: EV_RESIZE, EvData1: i})
+	}
+	ev, _ := q.Pop()
+	if ev.EvData1 != 1 {
+		t.Errorf("oldest left is %d, want 1", ev.EvData1)
+	}
+}
+
+func TestEventQueueCoalesces(t *testing.T) {
+	q := NewEventQueue()
+	q.Push(Event{Type: EV_MOUSEMOVE, Position: Vector{1, 1}})
+	q.Push(Event{Type: EV_MOUSEMOVE, Position: Vector{2, 2}})
+	q.Push(Event{Type: EV_PADAXIS, Pad: 0, EvData1: 0, EvData2: 100})
+	q.Push(Event{Type: EV_PADAXIS, Pad: 0, EvData1: 0, EvData2: 200})
+	q.Push(Event{Type: EV_PADAXIS, Pad: 0, EvData1: 1, EvData2: 300})
+	q.Push(Event{Type: EV_RESIZE, EvData1: 320})
+	q.Push(Event{Type: EV_RESIZE, EvData1: 640})
+
+	want := []Event{
+		{Type: EV_MOUSEMOVE, Position: Vector{2, 2}},
+		{Type: EV_PADAXIS, Pad: 0, EvData1: 0, EvData2: 200},
+		{Type: EV_PADAXIS, Pad: 0, EvData1: 1, EvData2: 300},
+		{Type: EV_RESIZE, EvData1: 320},
+		{Type: EV_RESIZE, EvData1: 640},
+	}
+	for i, w := range want {
+		if ev, ok := q.Pop(); !ok || ev != w {
+			t.Errorf("event %d is %+v, want %+v", i, ev, w)
+		}
+	}
+}
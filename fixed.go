@@ -0,0 +1,141 @@
+package main
+
+import "math"
+
+// Fixed is a 16.16 fixed point number. the simulation does all of its position
+// and velocity math in these so it comes out the same on every platform,
+// converting to whole pixels only when it's time to draw
+type Fixed int32
+
+const (
+	FIXED_SHIFT = 16
+	FIXED_ONE   = Fixed(1 << FIXED_SHIFT)
+	FIXED_HALF  = Fixed(1 << (FIXED_SHIFT - 1))
+)
+
+// whole number to fixed point
+func Fx(i int) Fixed {
+	return Fixed(i << FIXED_SHIFT)
+}
+
+// only meant for constants and tuning values, never for simulation results
+func FxFloat(f float64) Fixed {
+	return Fixed(f * float64(FIXED_ONE))
+}
+
+// whole part, rounded towards negative infinity
+func (f Fixed) Int() int32 {
+	return int32(f >> FIXED_SHIFT)
+}
+
+// nearest whole number
+func (f Fixed) Round() int32 {
+	return int32((f + FIXED_HALF) >> FIXED_SHIFT)
+}
+
+func (f Fixed) Float() float64 {
+	return float64(f) / float64(FIXED_ONE)
+}
+
+func (f Fixed) Mul(g Fixed) Fixed {
+	return Fixed((int64(f) * int64(g)) >> FIXED_SHIFT)
+}
+
+func (f Fixed) Div(g Fixed) Fixed {
+	return Fixed((int64(f) << FIXED_SHIFT) / int64(g))
+}
+
+func (f Fixed) Abs() Fixed {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// move f towards target by amt without passing it
+func (f Fixed) Approach(target, amt Fixed) Fixed {
+	if f < target {
+		f += amt
+		if f > target {
+			f = target
+		}
+	} else if f > target {
+		f -= amt
+		if f < target {
+			f = target
+		}
+	}
+	return f
+}
+
+// integer square root, rounded down
+func isqrt(n uint64) uint64 {
+	var root, bit uint64 = 0, 1 << 62
+	for bit > n {
+		bit >>= 2
+	}
+
+	for bit != 0 {
+		if n >= root+bit {
+			n -= root + bit
+			root = root>>1 + bit
+		} else {
+			root >>= 1
+		}
+		bit >>= 2
+	}
+	return root
+}
+
+// Vec2 is a position or velocity in fixed point pixels
+type Vec2 struct {
+	X Fixed
+	Y Fixed
+}
+
+func (a Vec2) Add(b Vec2) Vec2 {
+	return Vec2{a.X + b.X, a.Y + b.Y}
+}
+
+func (a Vec2) Sub(b Vec2) Vec2 {
+	return Vec2{a.X - b.X, a.Y - b.Y}
+}
+
+func (a Vec2) Scale(f Fixed) Vec2 {
+	return Vec2{a.X.Mul(f), a.Y.Mul(f)}
+}
+
+func (a Vec2) Dot(b Vec2) Fixed {
+	return Fixed((int64(a.X)*int64(b.X) + int64(a.Y)*int64(b.Y)) >> FIXED_SHIFT)
+}
+
+// the squares are summed in 64 bits so long vectors don't overflow on the way,
+// and a length too long to fit comes back as the longest there is
+func (a Vec2) Length() Fixed {
+	sq := uint64(int64(a.X)*int64(a.X)) + uint64(int64(a.Y)*int64(a.Y))
+	l := isqrt(sq)
+	if l > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return Fixed(l)
+}
+
+// unit vector in the same direction, or the zero vector if a is zero
+func (a Vec2) Normalize() Vec2 {
+	l := a.Length()
+	if l == 0 {
+		return Vec2{}
+	}
+	return Vec2{a.X.Div(l), a.Y.Div(l)}
+}
+
+// nearest whole pixel, for filling in render commands
+func (a Vec2) Pixels() Vector {
+	return Vector{a.X.Round(), a.Y.Round()}
+}
+
+// blend towards b for drawing. t is a render time fraction so this is the one
+// place floats are allowed near positions
+func (a Vec2) Lerp(b Vec2, t float64) Vec2 {
+	return Vec2{a.X + Fixed(float64(b.X-a.X)*t), a.Y + Fixed(float64(b.Y-a.Y)*t)}
+}
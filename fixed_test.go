@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	FIXED_MAX = Fixed(math.MaxInt32)
+	FIXED_MIN = Fixed(math.MinInt32)
+)
+
+func TestFixedMath(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want Fixed
+	}{
+		{"mul", Fx(3).Mul(Fx(4)), Fx(12)},
+		{"mul fraction", FxFloat(1.5).Mul(FIXED_HALF), FxFloat(0.75)},
+		{"mul negative", Fx(-3).Mul(Fx(4)), Fx(-12)},
+		{"mul two negatives", Fx(-3).Mul(Fx(-4)), Fx(12)},
+
+		// the smallest step there is, halved. Mul rounds down, so towards
+		// negative infinity, not towards zero
+		{"mul rounds down", Fixed(1).Mul(FIXED_HALF), 0},
+		{"mul negative rounds down", Fixed(-1).Mul(FIXED_HALF), -1},
+
+		// the product is worked out in 64 bits, so it only has to fit at the end
+		{"mul big", Fx(180).Mul(Fx(180)), Fx(32400)},
+		{"mul max by one", FIXED_MAX.Mul(FIXED_ONE), FIXED_MAX},
+		{"mul min by one", FIXED_MIN.Mul(FIXED_ONE), FIXED_MIN},
+		{"mul max by half", FIXED_MAX.Mul(FIXED_HALF), FIXED_MAX / 2},
+		{"mul min by minus half", FIXED_MIN.Mul(-FIXED_HALF), -(FIXED_MIN / 2)},
+
+		{"div", Fx(12).Div(Fx(4)), Fx(3)},
+		{"div fraction", Fx(1).Div(Fx(4)), FxFloat(0.25)},
+		{"div negative", Fx(-12).Div(Fx(4)), Fx(-3)},
+		{"div two negatives", Fx(-12).Div(Fx(-4)), Fx(3)},
+
+		// Div rounds towards zero, either side of it
+		{"div rounds down", Fixed(1).Div(Fx(2)), 0},
+		{"div negative rounds up", Fixed(-1).Div(Fx(2)), 0},
+		{"div thirds", Fx(1).Div(Fx(3)), 21845},
+		{"div negative thirds", Fx(-1).Div(Fx(3)), -21845},
+
+		{"div max by one", FIXED_MAX.Div(FIXED_ONE), FIXED_MAX},
+		{"div min by one", FIXED_MIN.Div(FIXED_ONE), FIXED_MIN},
+		{"div big by two", Fx(32000).Div(Fx(2)), Fx(16000)},
+		{"div into big", Fx(16000).Div(FIXED_HALF), Fx(32000)},
+
+		{"abs", Fx(-5).Abs(), Fx(5)},
+		{"approach up", Fx(1).Approach(Fx(2), FIXED_HALF), FxFloat(1.5)},
+		{"approach stops", Fx(1).Approach(Fx(2), Fx(3)), Fx(2)},
+		{"approach down stops", Fx(2).Approach(Fx(-1), Fx(5)), Fx(-1)},
+
+		{"length", Vec2{Fx(3), Fx(4)}.Length(), Fx(5)},
+		{"length negative", Vec2{Fx(-3), Fx(-4)}.Length(), Fx(5)},
+		{"length zero", Vec2{}.Length(), 0},
+		{"length tiny", Vec2{1, 0}.Length(), 1},
+		{"length long", Vec2{Fx(20000), Fx(15000)}.Length(), Fx(25000)},
+		{"length too long", Vec2{FIXED_MAX, FIXED_MAX}.Length(), FIXED_MAX},
+		{"length min", Vec2{FIXED_MIN, FIXED_MIN}.Length(), FIXED_MAX},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v (%d), want %v (%d)", tt.name, tt.got.Float(), tt.got, tt.want.Float(), tt.want)
+		}
+	}
+}
+
+func TestIsqrt(t *testing.T) {
+	tests := []struct{ n, want uint64 }{
+		{0, 0},
+		{1, 1},
+		{2, 1},
+		{3, 1},
+		{4, 2},
+		{99, 9},
+		{100, 10},
+		{1 << 62, 1 << 31},
+		{math.MaxUint64, math.MaxUint32},
+	}
+	for _, tt := range tests {
+		if got := isqrt(tt.n); got != tt.want {
+			t.Errorf("isqrt(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      Vec2
+		want   Vec2
+		length Fixed // how far off a unit length is allowed to be
+	}{
+		{"zero", Vec2{}, Vec2{}, 0},
+		{"right", Vec2{Fx(7), 0}, Vec2{FIXED_ONE, 0}, 0},
+		{"up", Vec2{0, Fx(-7)}, Vec2{0, -FIXED_ONE}, 0},
+		{"3 4 5", Vec2{Fx(3), Fx(4)}, Vec2{FxFloat(0.6), FxFloat(0.8)}, 1},
+		{"tiny", Vec2{1, 0}, Vec2{FIXED_ONE, 0}, 0},
+	}
+	for _, tt := range tests {
+		got := tt.v.Normalize()
+		if (got.X-tt.want.X).Abs() > 1 || (got.Y-tt.want.Y).Abs() > 1 {
+			t.Errorf("%s: got %v,%v, want %v,%v", tt.name, got.X.Float(), got.Y.Float(), tt.want.X.Float(), tt.want.Y.Float())
+		}
+		if tt.v != (Vec2{}) {
+			if l := got.Length(); (l - FIXED_ONE).Abs() > tt.length {
+				t.Errorf("%s: normalized length %v", tt.name, l.Float())
+			}
+		}
+	}
+}
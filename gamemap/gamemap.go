@@ -1,13 +1,44 @@
 package gamemap
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"os"
+)
 
-func Load(fname string) {
-	b := []byte(`{"Name":"Wednesday","Age":6,"Parents":["Gomez","Morticia"]}`)
-	var f interface{}
-	err := json.Unmarshal(b, &f)
+// the editor saves the collision layer without a name, and its tileset is
+// just there so the editor has something to draw
+const COLLISION_TILESET = "media/collision.png"
 
+// read a level from an Impact level editor json file
+func Load(fname string) (*Level, error) {
+	f, err := os.Open(fname)
 	if err != nil {
-		return
+		return nil, err
 	}
+	defer f.Close()
+
+	level := &Level{}
+	if err := json.NewDecoder(f).Decode(level); err != nil {
+		return nil, err
+	}
+
+	return level, nil
+}
+
+// the layer that says what's solid, or nil if the level doesn't have one
+func (l *Level) Collision() *Layer {
+	for i := range l.Layers {
+		if l.Layers[i].Name == "" || l.Layers[i].TilesetName == COLLISION_TILESET {
+			return &l.Layers[i]
+		}
+	}
+	return nil
+}
+
+// the value at a column and row, or zero off the edge of the layer
+func (l *Layer) At(col, row int) int {
+	if row < 0 || row >= len(l.Data) || col < 0 || col >= len(l.Data[row]) {
+		return 0
+	}
+	return l.Data[row][col]
 }
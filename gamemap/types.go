@@ -1,4 +1,32 @@
 package gamemap
 
+// Level is a level saved by the Impact level editor
 type Level struct {
+	Entities []Entity `json:"entities"`
+	Layers   []Layer  `json:"layer"`
+}
+
+// Entity is where the level wants an entity spawned. X and Y are its top left
+// corner in pixels, and Settings holds whatever was set on it in the editor
+type Entity struct {
+	Type     string                 `json:"type"`
+	X        int                    `json:"x"`
+	Y        int                    `json:"y"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// Layer is a grid of tiles. Data is indexed [row][column], and holds one more
+// than the tile's index in the tileset so that zero can mean no tile
+type Layer struct {
+	Name              string  `json:"name"`
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	TileSize          int     `json:"tilesize"`
+	TilesetName       string  `json:"tilesetName"`
+	Foreground        bool    `json:"foreground"`
+	Repeat            bool    `json:"repeat"`
+	Distance          float64 `json:"distance"`
+	Visible           int     `json:"visible"`
+	LinkWithCollision bool    `json:"linkWithCollision"`
+	Data              [][]int `json:"data"`
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// how many gamepads can be plugged in at once. each one gets a slot that stays
+// the same for as long as it's plugged in, and scenes only ever see the slot
+const MAX_PADS = 4
+
+// sent with EC_RUMBLE
+type Rumble struct {
+	Pad      int     // slot to rumble, or -1 for all of them
+	Strength float32 // 0 to 1
+	Length   time.Duration
+}
+
+type gamepad struct {
+	ctrl   *sdl.GameController
+	id     sdl.JoystickID
+	haptic *sdl.Haptic // nil if it can't rumble
+}
+
+// Gamepads keeps track of the game controllers that are plugged in. it lives
+// on the engine thread with the event pump. SDL sends an added event for
+// everything that's already plugged in when it starts, so there's no need to
+// look for them up front
+type Gamepads struct {
+	slots [MAX_PADS]*gamepad
+}
+
+// open a controller that was just plugged in, returning its slot or -1 if it
+// couldn't be opened or every slot is taken
+func (gp *Gamepads) Add(index int) int {
+	if !sdl.IsGameController(index) {
+		return -1
+	}
+
+	slot := -1
+	for i, pad := range gp.slots {
+		if pad == nil {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		fmt.Printf("Gamepad %d: no room for another\n", index)
+		return -1
+	}
+
+	ctrl := sdl.GameControllerOpen(index)
+	if ctrl == nil {
+		fmt.Printf("Gamepad %d: %s\n", index, sdl.GetError())
+		return -1
+	}
+	pad := &gamepad{ctrl: ctrl, id: ctrl.GetJoystick().InstanceID()}
+
+	// not being able to rumble isn't a problem, it just won't
+	if haptic, err := sdl.HapticOpenFromJoystick(ctrl.GetJoystick()); err == nil && haptic != nil {
+		if haptic.RumbleInit() == nil {
+			pad.haptic = haptic
+		} else {
+			haptic.Close()
+		}
+	}
+
+	fmt.Printf("Gamepad %d: %s\n", slot, ctrl.Name())
+	gp.slots[slot] = pad
+	return slot
+}
+
+// close a controller that was unplugged, returning the slot it was in or -1
+// if it wasn't one of ours
+func (gp *Gamepads) Remove(id sdl.JoystickID) int {
+	slot := gp.Slot(id)
+	if slot < 0 {
+		return -1
+	}
+
+	pad := gp.slots[slot]
+	if pad.haptic != nil {
+		pad.haptic.Close()
+	}
+	pad.ctrl.Close()
+	gp.slots[slot] = nil
+	return slot
+}
+
+// the slot a controller's events belong to, or -1 if it isn't open
+func (gp *Gamepads) Slot(id sdl.JoystickID) int {
+	for i, pad := range gp.slots {
+		if pad != nil && pad.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (gp *Gamepads) Rumble(r Rumble) {
+	for i, pad := range gp.slots {
+		if pad == nil || pad.haptic == nil || (r.Pad >= 0 && r.Pad != i) {
+			continue
+		}
+		pad.haptic.RumblePlay(r.Strength, uint32(r.Length/time.Millisecond))
+	}
+}
+
+func (gp *Gamepads) Close() {
+	for _, pad := range gp.slots {
+		if pad != nil {
+			gp.Remove(pad.id)
+		}
+	}
+}
@@ -1,216 +1,782 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"./tmx"
 )
 
+// GameScene is split across two goroutines. everything is owned by the scene's
+// own goroutine except for the fields marked as belonging to the engine thread.
+// the two sides only share the published states in states and the ready flag,
+// and the level and images, which are written before ready is set and only
+// read afterwards
 type GameScene struct {
-	ready          bool
-	sch            SceneChannels
-	lastTime       time.Time
-	keyState       [1024]bool
-	prevState      GameState
-	state          GameState
-	renderingState GameState
-	rcmds          RenderCommandList
-	images         map[string]Image
-	gmap           tmx.Map
+	ready     int32         // set atomically once the first state has been published
+	NoLerp    bool          // draw the latest state as is instead of interpolating, for debugging
+	TickRate  int           // simulation steps per second, DEFAULT_TICKRATE if zero
+	MaxSteps  int           // most steps to run to catch up after a stall, DEFAULT_MAXSTEPS if zero
+	Level     string        // map file in the base folder, DEFAULT_LEVEL if empty
+	Seed      uint32        // starts the simulation's random numbers, taken from the clock if zero
+	Camera    CameraConfig  // how the camera follows the player, DEFAULT_CAMERA if zero
+	Record    string        // write every step's command to this file
+	Play      string        // take commands from this recording instead of input until it runs out
+	Connect   string        // play on the server at this address instead of on our own
+	NetLag    time.Duration // hold back packets to the server by this much, for testing
+	NetLoss   float64       // fraction of packets to the server to lose, for testing
+	Resume    *SaveFile     // carry on from this save, on its level
+	sch       SceneChannels
+	lastTime  time.Time
+	step      time.Duration
+	acc       time.Duration
+	unstepped uint32 // buttons from input that no step has run with yet
+	timeScale float64
+	input     *Input
+	prevState GameState
+	state     GameState
+	states    *StateBuffer
+	rcmds     RenderCommandList // engine thread
+	lerped    GameState         // engine thread
+	images    map[string]Image
+	sprites   *SpriteSet
+	pending   *LoadBatch
+	gmap      tmx.Map
+	tileAnims *TileAnimations
+	scenery   []SpawnObject // tile objects that are only there to be looked at
+	rooms     []Rect        // from CAMERA_ROOM objects
+	zoom      int32
+	screen    Size // from the last EV_RESIZE
+	collision *CollisionMap
+	contacts  Broadphase
+	world     World
+	recorder  *Recorder
+	replay    *Replay
+	client    *Client
 }
 
+const (
+	DEFAULT_TICKRATE = 120
+	DEFAULT_MAXSTEPS = 8
+	DEFAULT_LEVEL    = "testlevel.tmx"
+	SPRITES_FILE     = "sprites.json"
+	BINDINGS_FILE    = "bindings.json"
+
+	SLOWMO_SCALE = 0.25
+	MAX_ZOOM     = 4
+)
+
 // load and run the scene. this is called inside a goroutine from the engine
 func (s *GameScene) Load(sceneCh SceneChannels) {
 	s.sch = sceneCh
 	s.images = make(map[string]Image)
+	s.states = NewStateBuffer()
+	s.input = NewInput(s.loadBindings())
+
+	// a recording says which level it was and how it started
+	if s.Play != "" {
+		rp, err := OpenReplay(s.Play)
+		if err != nil {
+			s.sch.Err <- err
+			return
+		}
+		s.replay = rp
+		s.Level, s.Seed, s.TickRate = rp.Header.Level, rp.Header.Seed, rp.Header.TickRate
+	}
 
-	// load our assets
-	playerImage := "player.png"
-	s.sch.Eng <- EngineCommand{Id: EC_LOADIMAGE, Data: "base/" + playerImage}
-	img := <-s.sch.Eng
-	s.images[playerImage] = img.Data.(Image)
+	// a save says which level it was on too
+	if s.Resume != nil {
+		s.Level = s.Resume.Level
+	}
+
+	// so does a server
+	if s.Connect != "" {
+		c, err := DialUDP(s.Connect, s.NetLag, s.NetLoss)
+		if err != nil {
+			s.sch.Err <- err
+			return
+		}
+		s.client = c
+		s.Level, s.TickRate = c.Level, c.TickRate
+	}
 
 	// load our level here
-	freader, err := os.Open("base/testlevel.tmx")
+	if s.Level == "" {
+		s.Level = DEFAULT_LEVEL
+	}
+	level, err := LoadLevel("base/" + s.Level)
 	if err != nil {
+		s.sch.Err <- err
 		return
 	}
 
-	gmap, err := tmx.Read(freader)
+	s.sprites, err = LoadSprites("base/" + SPRITES_FILE)
 	if err != nil {
+		s.sch.Err <- err
 		return
 	}
-	s.gmap = *gmap
-
-	for i := range s.gmap.Tilesets {
-		ts := &s.gmap.Tilesets[i]
-		s.sch.Eng <- EngineCommand{Id: EC_LOADIMAGE, Data: "base/" + ts.Image.Source}
-		img := <-s.sch.Eng
-		s.images[ts.Image.Source] = img.Data.(Image)
-	}
-
-	currEnt := 0
-	localEnt := 0
-	for i := range s.gmap.ObjectGroups {
-		for j := range s.gmap.ObjectGroups[i].Objects {
-			obj := s.gmap.ObjectGroups[i].Objects[j]
-			ent := Entity{}
-			switch obj.Type {
-			case "player_start":
-				ent.Valid = true
-				ent.Pos = Vector{int32(obj.X * 4), int32((obj.Y - 32) * 4)}
-				ent.Size = Size{64, 128}
-				ent.Image = s.images["player.png"].Id
-				localEnt = currEnt
-			}
 
-			if ent.Valid {
-				s.state.Entities[currEnt] = ent
-			}
-			currEnt++
+	// load our assets in one go, the engine draws a loading screen until we're ready
+	assets := s.sprites.Images()
+	for i := range level.Map.Tilesets {
+		assets = append(assets, level.Map.Tilesets[i].Image.Source)
+	}
+
+	if err := s.loadImages(assets); err != nil {
+		return
+	}
+	s.sprites.Bind(s.images)
+
+	// a level with things in it we don't know about is still playable, so
+	// those are only warnings
+	for _, err := range s.setup(level) {
+		s.sch.Err <- &AssetError{Path: s.Level, Err: err}
+	}
+	if s.Resume != nil {
+		st, err := s.Resume.State(s.sprites)
+		if err != nil {
+			s.sch.Err <- err
+			return
 		}
+		s.resume(st)
 	}
 
-	s.state.LocalEnt = &s.state.Entities[localEnt]
-	s.state.Camera.SetSize(Size{1280, 720})
-	s.state.Camera.SetBounds(Size{int32(s.gmap.Width * 64), int32(s.gmap.Height * 64)})
+	// the header needs the seed setup picked
+	if s.Record != "" {
+		rec, err := NewRecorder(s.Record, ReplayHeader{Level: s.Level, Seed: s.Seed, TickRate: s.TickRate})
+		if err != nil {
+			s.sch.Err <- err
+			return
+		}
+		s.recorder = rec
+	}
+
+	s.timeScale = 1
+
+	// publish the starting state so there is something to draw
+	s.publish()
+	atomic.StoreInt32(&s.ready, 1)
 
+	// wake up about once a step and run however many fixed steps of simulation
+	// the real time since last wakeup adds up to
 	s.lastTime = time.Now()
-	loop := time.Tick(8 * time.Millisecond)
-	s.ready = true
-	for now := range loop {
-		dt := int32(time.Since(s.lastTime).Nanoseconds())
-
-		// check for new inputs and generate a usercommand out of them
-		var ev Event
-
-		running := true
-		for running {
-			select {
-			case ev = <-s.sch.Ev: // we have a new event
-				switch ev.Type {
-				case EV_KEY:
-					s.keyState[ev.EvData1] = ev.Down
-				}
-			default:
-				running = false
-			}
+	loop := time.NewTicker(s.step)
+	defer loop.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-s.sch.Ctx.Done():
+			return
+		case now = <-loop.C:
 		}
 
-		userCmd := UserCommand{}
-		userCmd.Up = btoi(s.keyState[82]) * 255
-		userCmd.Right = btoi(s.keyState[79]) * 255
-		userCmd.Down = btoi(s.keyState[81]) * 255
-		userCmd.Left = btoi(s.keyState[80]) * 255
+		s.acc += time.Duration(float64(now.Sub(s.lastTime)) * s.timeScale)
+		s.lastTime = now
 
-		s.update(dt, userCmd)
+		// if we've fallen too far behind, drop the time we can't make up rather
+		// than spending even longer trying to catch up next time
+		if s.acc > s.step*time.Duration(s.MaxSteps) {
+			s.acc = s.step * time.Duration(s.MaxSteps)
+		}
+
+		if err := s.runSteps(s.pollInput()); err != nil {
+			s.sch.Err <- err
+			return
+		}
+
+		s.publish()
+		s.sendRumbles()
 
 		// do a non blocking read on our render command channel to clear it if a previous list exists
 		select {
 		case _ = <-s.sch.RCmd:
 		default:
 		}
+	}
+}
 
-		s.lastTime = now
+// check for new inputs and generate a usercommand out of them
+// runs as many steps as the time built up covers, maybe none. input only
+// reports a press once, so buttons wait here until a step has seen them or a
+// quick tap between steps would never reach the simulation
+func (s *GameScene) runSteps(cmd UserCommand) error {
+	cmd.Buttons |= s.unstepped
+	s.unstepped = cmd.Buttons
+	for s.acc >= s.step {
+		if err := s.tick(cmd); err != nil {
+			return err
+		}
+		s.acc -= s.step
+		s.unstepped = 0
 	}
+	return nil
 }
 
-func (s *GameScene) update(dt int32, userCmd UserCommand) {
-	s.sch.stateLock.Lock()
-	s.prevState = s.state
+func (s *GameScene) pollInput() UserCommand {
+	// the queue drops what doesn't fit, and any of it could have been a key
+	// coming back up, so start over rather than leave something stuck down
+	if s.sch.Ev.Dropped() > 0 {
+		s.input.Clear()
+	}
 
-	st := &s.state
-	st.FrameTime = dt
-	st.Time = s.prevState.Time + dt/1000000
+	for {
+		ev, ok := s.sch.Ev.Pop()
+		if !ok {
+			break
+		}
 
-	for i := 0; i < len(st.Entities); i++ {
-		var ent *Entity = &st.Entities[i]
-		if !ent.Valid {
+		switch ev.Type {
+		case EV_KEY:
+			s.input.Key(ev.EvData1, ev.Down)
+		case EV_MOUSEMOVE:
+			s.input.MouseMove(ev.Position)
+		case EV_MOUSECLICK:
+			s.input.MouseMove(ev.Position)
+			s.input.MouseButton(ev.EvData1, ev.Down)
+		case EV_PADBUTTON:
+			s.input.PadButton(ev.Pad, ev.EvData1, ev.Down)
+		case EV_PADAXIS:
+			s.input.PadAxis(ev.Pad, ev.EvData1, ev.EvData2)
+		case EV_PADREMOVED:
+			s.input.PadRemoved(ev.Pad)
+		case EV_RESIZE:
+			s.screen = Size{int32(ev.EvData1), int32(ev.EvData2)}
+			s.state.Camera.SetSize(s.screen)
+		}
+	}
+
+	s.input.Update()
+	s.toggleTimeScale()
+	s.changeZoom()
+	s.quickSaveLoad()
+	s.rebind()
+
+	// what's on screen is a little behind the simulation, but not by enough
+	// to matter for where the mouse is pointing
+	cmd := s.input.Command()
+	view := s.state.Camera
+	view.SetZoom(s.zoom)
+	cmd.Mouse = view.ToWorld(s.input.Mouse())
+	return cmd
+}
+
+// pass on any rumbles the simulation asked for
+func (s *GameScene) sendRumbles() {
+	for _, r := range s.world.Rumbles {
+		s.sch.Eng <- EngineCommand{Id: EC_RUMBLE, Data: r}
+		<-s.sch.Eng
+	}
+	s.world.Rumbles = s.world.Rumbles[:0]
+}
+
+// read the player's bindings. the first time there aren't any, the defaults
+// are written out so there's a file to edit. anything wrong with the file
+// only gets a warning, and the bindings it didn't get to stay as defaults
+func (s *GameScene) loadBindings() InputMap {
+	fname := "base/" + BINDINGS_FILE
+	m, err := LoadInputMap(fname)
+	if os.IsNotExist(err) {
+		err = m.Save(fname)
+	}
+	if err != nil {
+		s.sch.Err <- &AssetError{Path: BINDINGS_FILE, Err: err}
+	}
+	return m
+}
+
+// the rebind action. press it, then whatever does the action to change, then
+// the new input for that action. the old input stops doing anything, and the
+// new one stops doing whatever it did before. the bindings are saved straight
+// away so they're there next time
+func (s *GameScene) rebind() {
+	if !s.input.Pressed(ACT_REBIND) {
+		return
+	}
+
+	in := s.input
+	fmt.Println("Rebind: press the input to change")
+	in.Capture(func(old Binding) {
+		acts := in.Map.actions(old)
+		if len(acts) == 0 {
+			fmt.Printf("Rebind: %s isn't bound to anything\n", old)
+			return
+		}
+		act := acts[0]
+
+		fmt.Printf("Rebind: press the new input for %s\n", act)
+		in.Capture(func(b Binding) {
+			in.Map.Unbind(old)
+			in.Map.Bind(act, b)
+			fmt.Printf("Rebind: %s is now %s\n", act, b)
+			if err := in.Map.Save("base/" + BINDINGS_FILE); err != nil {
+				fmt.Printf("Rebind: %s\n", err)
+			}
+		})
+	})
+}
+
+// run one step with the command from the recording being played, or the
+// live one once there isn't one, and write it down if recording
+func (s *GameScene) tick(live UserCommand) error {
+	if s.client != nil {
+		return s.client.Step(s, live)
+	}
+
+	cmd := live
+	var want uint32
+	playing := false
+	if s.replay != nil {
+		c, sum, err := s.replay.Next()
+		switch err {
+		case nil:
+			cmd, want, playing = c, sum, true
+		case io.EOF:
+			s.replay.Close()
+			s.replay = nil
+		default:
+			return err
+		}
+	}
+
+	s.update(cmd)
+
+	// the checksum walks the whole state, so it's only worked out when
+	// something is going to look at it
+	if !playing && s.recorder == nil {
+		return nil
+	}
+	sum := s.state.Checksum()
+	if playing && sum != want {
+		return &DesyncError{Tick: s.state.Tick, Want: want, Got: sum}
+	}
+	if s.recorder != nil {
+		return s.recorder.Record(cmd, sum)
+	}
+	return nil
+}
+
+// give back everything the scene loaded. called once Load has returned
+func (s *GameScene) Unload() {
+	atomic.StoreInt32(&s.ready, 0)
+
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			fmt.Printf("Recording: %s\n", err)
+		}
+		s.recorder = nil
+	}
+	if s.replay != nil {
+		s.replay.Close()
+		s.replay = nil
+	}
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+
+	// a load that was cancelled part way still has to finish before its
+	// images can be given back
+	if s.pending != nil {
+		<-s.pending.Done()
+		s.keepImages(s.pending)
+		s.pending = nil
+	}
+
+	s.releaseImages()
+}
+
+func (s *GameScene) Ready() bool {
+	return atomic.LoadInt32(&s.ready) != 0
+}
+
+// copy the simulation state somewhere the engine thread can draw it from, along
+// with the state before it so the engine can draw in between the two
+func (s *GameScene) publish() {
+	snap := s.states.Back()
+	snap.Prev = s.prevState
+	snap.Cur = s.state
+	snap.Published = time.Now()
+	snap.Step = s.step
+	snap.Leftover = s.acc
+	snap.TimeScale = s.timeScale
+	snap.Zoom = s.zoom
+	s.states.Publish()
+}
+
+// ask the engine to load a set of images from the base folder in the
+// background, and wait for all of them to finish. anything that fails is
+// reported to the engine and drawn with the missing texture. only returns an
+// error if the scene was cancelled while waiting
+func (s *GameScene) loadImages(fnames []string) error {
+	// each name is only asked for once so it only holds one reference
+	paths := []string{}
+	seen := make(map[string]bool)
+	for _, fname := range fnames {
+		if _, ok := s.images[fname]; ok || seen[fname] {
 			continue
 		}
+		seen[fname] = true
+		paths = append(paths, "base/"+fname)
+	}
 
-		ent.Vel.X = 4 * int32(userCmd.Right-userCmd.Left) / 255
-		ent.Vel.Y = 4 * int32(userCmd.Down-userCmd.Up) / 255
+	s.sch.Eng <- EngineCommand{Id: EC_LOADIMAGES, Data: paths}
+	reply := <-s.sch.Eng
+	batch := reply.Data.(*LoadBatch)
 
-		// move all entities based on velocity
-		ent.Pos.X += st.FrameTime / 5000000 * ent.Vel.X
-		ent.Pos.Y += st.FrameTime / 5000000 * ent.Vel.Y
+	select {
+	case <-batch.Done():
+	case <-s.sch.Ctx.Done():
+		// Unload picks up whatever this batch loaded so it can be released
+		s.pending = batch
+		return s.sch.Ctx.Err()
 	}
 
-	// move the camera so that the player is in the bounding box
-	if int(st.LocalEnt.Pos.X) > st.Camera.Right-200 {
-		st.Camera.Set(Vector{st.LocalEnt.Pos.X - st.Camera.Size.W + 200, int32(st.Camera.Top)})
-	} else if int(st.LocalEnt.Pos.X) < st.Camera.Left+200 {
-		st.Camera.Set(Vector{st.LocalEnt.Pos.X - 200, int32(st.Camera.Top)})
+	s.keepImages(batch)
+	for _, err := range batch.Errors {
+		if err != nil {
+			s.sch.Err <- err
+		}
 	}
 
-	if int(st.LocalEnt.Pos.Y) > st.Camera.Bottom-200 {
-		st.Camera.Set(Vector{int32(st.Camera.Left), st.LocalEnt.Pos.Y - st.Camera.Size.H + 200})
-	} else if int(st.LocalEnt.Pos.Y) < st.Camera.Top+200 {
-		st.Camera.Set(Vector{int32(st.Camera.Left), st.LocalEnt.Pos.Y - 200})
+	return nil
+}
+
+// remember the images from a finished batch by the names the scene asked for
+func (s *GameScene) keepImages(batch *LoadBatch) {
+	for i, path := range batch.Names {
+		s.images[strings.TrimPrefix(path, "base/")] = batch.Images[i]
+	}
+}
+
+// release every image this scene has loaded
+func (s *GameScene) releaseImages() {
+	for fname, img := range s.images {
+		s.sch.Eng <- EngineCommand{Id: EC_RELEASEIMAGE, Data: img.Id}
+		<-s.sch.Eng
+		delete(s.images, fname)
+	}
+}
+
+// pause and slow motion debug actions. pressing either again goes back to normal speed
+func (s *GameScene) toggleTimeScale() {
+	var scale float64
+	switch {
+	case s.input.Pressed(ACT_PAUSE):
+		scale = 0
+	case s.input.Pressed(ACT_SLOWMO):
+		scale = SLOWMO_SCALE
+	default:
+		return
+	}
+
+	if s.timeScale == scale {
+		s.timeScale = 1
+	} else {
+		s.timeScale = scale
+	}
+}
+
+// zoom debug actions. only the camera that's drawn is zoomed, the one in the
+// state always sees the whole screen so zooming can't change how the game plays
+func (s *GameScene) changeZoom() {
+	switch {
+	case s.input.Pressed(ACT_ZOOMIN):
+		if s.zoom < MAX_ZOOM {
+			s.zoom++
+		}
+	case s.input.Pressed(ACT_ZOOMOUT):
+		if s.zoom > 1 {
+			s.zoom--
+		}
+	}
+}
+
+// build the starting state for a level and spawn everything in it, returning
+// anything that couldn't be spawned. this needs nothing from the engine, so a
+// scene can be set up and stepped through update without one, and without
+// sprites if they haven't been loaded
+func (s *GameScene) setup(level *Level) []error {
+	s.gmap = level.Map
+	s.collision = level.Collision
+	s.tileAnims = NewTileAnimations(&s.gmap)
+
+	// camera rooms aren't entities, so they're taken out before spawning
+	s.scenery = nil
+	s.rooms = nil
+	objects := make([]SpawnObject, 0, len(level.Objects))
+	for _, obj := range level.Objects {
+		switch {
+		case obj.Type == CAMERA_ROOM:
+			pos := obj.Pos.Pixels()
+			s.rooms = append(s.rooms, Rect{pos.X, pos.Y, obj.Size.W, obj.Size.H})
+			continue
+		case obj.Tile != nil && spawnFuncs[obj.Type] == nil:
+			s.scenery = append(s.scenery, obj)
+		}
+		objects = append(objects, obj)
+	}
+
+	if s.TickRate <= 0 {
+		s.TickRate = DEFAULT_TICKRATE
+	}
+	if s.MaxSteps <= 0 {
+		s.MaxSteps = DEFAULT_MAXSTEPS
+	}
+	s.step = time.Second / time.Duration(s.TickRate)
+
+	if s.Camera == (CameraConfig{}) {
+		s.Camera = DEFAULT_CAMERA
+	}
+
+	if s.Seed == 0 {
+		s.Seed = uint32(time.Now().UnixNano())
+	}
+	s.state = GameState{Rand: s.Seed}
+	s.contacts = Broadphase{}
+	s.world = World{State: &s.state, Collision: s.collision, Sprites: s.sprites, Step: s.step}
+	errs := SpawnObjects(&s.world, objects)
+
+	// the engine says how big the screen is before the scene starts, but
+	// there's no engine when stepping a scene on its own
+	if s.screen == (Size{}) {
+		s.screen = Size{VIRTUAL_WIDTH, VIRTUAL_HEIGHT}
+	}
+	if s.zoom == 0 {
+		s.zoom = 1
+	}
+	cam := &s.state.Camera
+	cam.SetSize(s.screen)
+	cam.SetBounds(Size{int32(s.gmap.Width * s.gmap.TileWidth), int32(s.gmap.Height * s.gmap.TileHeight)})
+	if player := s.state.Entities.Get(s.state.LocalEnt); player != nil {
+		cam.Snap(&s.Camera, player, s.rooms)
+	}
+
+	s.prevState = s.state
+	return errs
+}
+
+// advance the simulation by one fixed step
+func (s *GameScene) update(userCmd UserCommand) {
+	s.prevState = s.state
+
+	st := &s.state
+	st.Tick++
+
+	// only what was alive at the start of the step gets to think. anything
+	// spawned along the way starts next step, and anything despawned keeps its
+	// slot until everything has thought so ids can't be reused in the middle
+	s.world.Cmd = userCmd
+	ents := &st.Entities
+	for _, slot := range ents.LiveSlots() {
+		ent := &ents.Ents[slot]
+		if !ent.Valid {
+			continue
+		}
+		if think := entityClasses[ent.Type].Think; think != nil {
+			think(&s.world, ent)
+		}
+		s.sprites.Advance(&ent.Anim, s.step)
 	}
 
-	s.sch.stateLock.Unlock()
+	ents.Compact()
+
+	// now that everything has moved, see what is touching what. the contacts
+	// go by slot, so this has to come after compacting for it to see freed
+	// slots as gone before anything can be spawned into them
+	s.contacts.Update(ents.Ents[:], ents.LiveSlots(), s.touch)
+
+	st.Camera.Update(&s.Camera, ents.Get(st.LocalEnt), s.rooms, st.Random)
+}
+
+// run a step of only the local player, for a client to get ahead of what the
+// server has sent. everything else stays where the server last put it
+func (s *GameScene) predict(cmd UserCommand) {
+	s.prevState = s.state
+
+	st := &s.state
+	st.Tick++
+
+	s.world.Cmd = cmd
+	ents := &st.Entities
+	if ent := ents.Get(st.LocalEnt); ent != nil {
+		if think := entityClasses[ent.Type].Think; think != nil {
+			think(&s.world, ent)
+		}
+	}
+	for _, slot := range ents.LiveSlots() {
+		if ent := &ents.Ents[slot]; ent.Valid {
+			s.sprites.Advance(&ent.Anim, s.step)
+		}
+	}
+	ents.Compact()
+
+	st.Camera.Update(&s.Camera, ents.Get(st.LocalEnt), s.rooms, st.Random)
 }
 
-func (s *GameScene) render() *RenderCommandList {
-	s.sch.stateLock.Lock()
-	s.renderingState = s.state
-	s.sch.stateLock.Unlock()
+// two entities touched, are still touching, or stopped touching. each side is
+// told about the other through its class. once one of them has despawned only
+// the one that's left hears about it
+func (s *GameScene) touch(a, b int, phase ContactPhase) {
+	ents := &s.state.Entities
+	ea, eb := &ents.Ents[a], &ents.Ents[b]
+
+	if fn := entityClasses[ea.Type].Touch; fn != nil && ea.Valid {
+		fn(&s.world, ea, eb, phase)
+	}
+	if fn := entityClasses[eb.Type].Touch; fn != nil && eb.Valid {
+		fn(&s.world, eb, ea, phase)
+	}
+}
 
+// build a command list from the latest published state. called on the engine thread
+func (s *GameScene) Render() *RenderCommandList {
 	s.rcmds = RenderCommandList{}
-	st := &s.renderingState
+	snap := s.states.Latest()
+	st := s.interpolate(snap, time.Now())
 
+	// the list is a fixed size, so a view with more in it than fits draws
+	// what it can rather than running off the end
 	num := 0
+	full := len(s.rcmds.Commands)
 
-	s.rcmds.Commands[num] = RenderCommand{Id: RC_RECT, Pos: Vector{0, 0}, Size: st.Camera.Size, BackColor: RGBA{168, 168, 168, 255}}
+	// the debug zoom is only put on the camera that's drawn
+	cam := st.Camera
+	cam.SetZoom(snap.Zoom)
+	s.rcmds.Commands[num] = RenderCommand{Id: RC_RECT, Pos: Vector{0, 0}, Size: cam.Screen, BackColor: RGBA{168, 168, 168, 255}}
 	num++
 
-	var y, x, i, tid int
-	maxX := min(st.Camera.Right/64+1, s.gmap.Width)
-	maxY := min(st.Camera.Bottom/64+1, s.gmap.Height)
+	// animated tiles go by simulated time so they stop when the game does
+	simTime := time.Duration(st.Tick) * s.step
+
+	var y, x, i int
+	tw, th := s.gmap.TileWidth, s.gmap.TileHeight
+	maxX := min(cam.Right/tw+1, s.gmap.Width)
+	maxY := min(cam.Bottom/th+1, s.gmap.Height)
+	tileSize := cam.ScaleSize(Size{int32(tw), int32(th)})
 
 	for i = range s.gmap.Layers {
 		layer := &s.gmap.Layers[i]
-		tsw := layer.Tileset.Image.Width / layer.Tileset.TileWidth
-		for y = max(0, st.Camera.Top/64); y < maxY; y++ {
-			for x = max(0, st.Camera.Left/64); x < maxX; x++ {
-				tid = int(layer.DecodedTiles[y*s.gmap.Width+x].ID)
-				if tid == 0 {
+		for y = max(0, cam.Top/th); y < maxY && num < full; y++ {
+			for x = max(0, cam.Left/tw); x < maxX && num < full; x++ {
+				tile := layer.DecodedTiles[y*s.gmap.Width+x]
+				if tile.IsNil() {
 					continue
 				}
 
-				cmd := &s.rcmds.Commands[num]
-				cmd.Id = RC_PIC
-				cmd.Pos = Vector{X: int32(x*64 - st.Camera.Left), Y: int32(y*64 - st.Camera.Top)}
-				cmd.Size = Size{W: 64, H: 64}
-				cmd.ImageId = int32(s.images[layer.Tileset.Image.Source].Id)
-				cmd.ImgSize = Size{int32(layer.Tileset.TileWidth), int32(layer.Tileset.TileHeight)}
-				cmd.ImgPos = Vector{int32(tid%tsw) * int32(layer.Tileset.TileWidth), int32(tid/tsw) * int32(layer.Tileset.TileHeight)}
+				pos := cam.ToScreen(Vector{X: int32(x * tw), Y: int32(y * th)})
+				s.tileCommand(&s.rcmds.Commands[num], tile, pos, tileSize, simTime)
 				num++
 			}
 		}
 
 	}
 
-	for _, ent := range st.Entities {
+	for i = 0; i < len(s.scenery) && num < full; i++ {
+		obj := &s.scenery[i]
+		pos := obj.Pos.Pixels()
+		if int(pos.X) >= cam.Right || int(pos.X+obj.Size.W) <= cam.Left ||
+			int(pos.Y) >= cam.Bottom || int(pos.Y+obj.Size.H) <= cam.Top {
+			continue
+		}
+
+		s.tileCommand(&s.rcmds.Commands[num], obj.Tile, cam.ToScreen(pos), cam.ScaleSize(obj.Size), simTime)
+		num++
+	}
+
+	for _, slot := range st.Entities.LiveSlots() {
+		if num == full {
+			break
+		}
+		ent := &st.Entities.Ents[slot]
 		if !ent.Valid {
 			continue
 		}
 
 		cmd := &s.rcmds.Commands[num]
 		cmd.Id = RC_PIC
-		cmd.Pos.X = ent.Pos.X - int32(st.Camera.Left)
-		cmd.Pos.Y = ent.Pos.Y - int32(st.Camera.Top)
-		cmd.Size = ent.Size
-		cmd.ImageId = int32(ent.Image)
-		cmd.ImgSize = Size{16, 32}
+		cmd.Pos = cam.ToScreen(ent.Pos.Pixels())
+		cmd.Size = cam.ScaleSize(ent.Size)
 		cmd.BackColor = ent.Color
 
+		// anything without a sprite yet is drawn as a box. sprites are drawn
+		// at their own size, standing at the bottom middle of the entity
+		image, frame, flip, ok := s.sprites.Frame(&ent.Anim)
+		if !ok {
+			cmd.Id = RC_RECT
+		} else {
+			size := cmd.Size
+			cmd.Size = cam.ScaleSize(frame.Size)
+			cmd.Pos.X += (size.W - cmd.Size.W) / 2
+			cmd.Pos.Y += size.H - cmd.Size.H
+		}
+		cmd.ImageId = image
+		cmd.ImgPos = frame.Pos
+		cmd.ImgSize = frame.Size
+		cmd.Flip = flip
+
 		num++
 	}
 
 	s.rcmds.NumCommands = int32(num)
 	return &s.rcmds
 }
+
+// fill in a command to draw a tile, or whichever frame of it is showing if it's
+// animated
+func (s *GameScene) tileCommand(cmd *RenderCommand, tile *tmx.DecodedTile, pos Vector, size Size, simTime time.Duration) {
+	ts := tile.Tileset
+	tsw := ts.Image.Width / ts.TileWidth
+	tid := int(s.tileAnims.Frame(ts, tile.ID, simTime))
+
+	cmd.Id = RC_PIC
+	cmd.Pos = pos
+	cmd.Size = size
+	cmd.ImageId = int32(s.images[ts.Image.Source].Id)
+	cmd.ImgSize = Size{int32(ts.TileWidth), int32(ts.TileHeight)}
+	cmd.ImgPos = Vector{int32(tid%tsw) * int32(ts.TileWidth), int32(tid/tsw) * int32(ts.TileHeight)}
+}
+
+// the simulation is always a little ahead of real time, so draw one step
+// behind and blend from the previous state towards the current one by however
+// much time has built up towards the next step. entity positions and the
+// camera are interpolated, everything else comes from the newer state
+func (s *GameScene) interpolate(snap *Snapshot, now time.Time) *GameState {
+	if s.NoLerp || snap.Step <= 0 {
+		return &snap.Cur
+	}
+
+	pending := snap.Leftover + time.Duration(float64(now.Sub(snap.Published))*snap.TimeScale)
+	alpha := float64(pending) / float64(snap.Step)
+	if alpha >= 1 {
+		return &snap.Cur
+	}
+	if alpha < 0 {
+		alpha = 0
+	}
+
+	prev, cur := &snap.Prev, &snap.Cur
+	st := &s.lerped
+	*st = *cur
+
+	// an entity only blends if the same one was in its slot last step, not
+	// one that just spawned into it
+	for _, slot := range cur.Entities.LiveSlots() {
+		from, to := &prev.Entities.Ents[slot], &cur.Entities.Ents[slot]
+		if !from.Valid || from.Id != to.Id {
+			continue
+		}
+
+		st.Entities.Ents[slot].Pos = from.Pos.Lerp(to.Pos, alpha)
+	}
+
+	st.Camera.Set(Vector{
+		lerp(int32(prev.Camera.Left), int32(cur.Camera.Left), alpha),
+		lerp(int32(prev.Camera.Top), int32(cur.Camera.Top), alpha),
+	})
+
+	return st
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Action is something the player can do, whatever it's bound to
+type Action uint8
+
+const (
+	ACT_LEFT Action = iota
+	ACT_RIGHT
+	ACT_UP
+	ACT_DOWN
+	ACT_JUMP
+	ACT_SHOOT
+	ACT_POGO
+
+	// the quicksave slot
+	ACT_QUICKSAVE
+	ACT_QUICKLOAD
+
+	// change what an input does, see GameScene.rebind
+	ACT_REBIND
+
+	// debug actions, these work even while the game is paused
+	ACT_PAUSE
+	ACT_SLOWMO
+	ACT_ZOOMIN
+	ACT_ZOOMOUT
+
+	NUM_ACTIONS
+)
+
+// how actions are written in the bindings file
+var actionNames = [NUM_ACTIONS]string{
+	ACT_LEFT:      "left",
+	ACT_RIGHT:     "right",
+	ACT_UP:        "up",
+	ACT_DOWN:      "down",
+	ACT_JUMP:      "jump",
+	ACT_SHOOT:     "shoot",
+	ACT_POGO:      "pogo",
+	ACT_QUICKSAVE: "quicksave",
+	ACT_QUICKLOAD: "quickload",
+	ACT_REBIND:    "rebind",
+	ACT_PAUSE:     "pause",
+	ACT_SLOWMO:    "slowmo",
+	ACT_ZOOMIN:    "zoomin",
+	ACT_ZOOMOUT:   "zoomout",
+}
+
+func (a Action) String() string {
+	if a < NUM_ACTIONS {
+		return actionNames[a]
+	}
+	return fmt.Sprintf("action %d", a)
+}
+
+type BindKind uint8
+
+const (
+	BK_KEY       BindKind = 1 + iota // Code is a scancode
+	BK_MOUSE                         // Code is a mouse button
+	BK_PADBUTTON                     // Code is a gamepad button
+	BK_PADAXIS                       // Code is a gamepad axis, pushed whichever way Dir says
+)
+
+var bindKindNames = map[BindKind]string{
+	BK_KEY:       "key",
+	BK_MOUSE:     "mouse",
+	BK_PADBUTTON: "button",
+	BK_PADAXIS:   "axis",
+}
+
+// Binding is one input that sets off an action
+type Binding struct {
+	Kind BindKind
+	Code int
+	Dir  int // for axes, -1 or 1
+}
+
+// bindings are written as kind:code, like key:44 or mouse:1. axes have the
+// way they're pushed on the end, like axis:0- for left on the first stick
+func (b Binding) String() string {
+	s := fmt.Sprintf("%s:%d", bindKindNames[b.Kind], b.Code)
+	if b.Kind == BK_PADAXIS {
+		if b.Dir < 0 {
+			s += "-"
+		} else {
+			s += "+"
+		}
+	}
+	return s
+}
+
+func ParseBinding(s string) (Binding, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return Binding{}, fmt.Errorf("binding %q: needs to be kind:code", s)
+	}
+
+	var b Binding
+	for kind, name := range bindKindNames {
+		if s[:i] == name {
+			b.Kind = kind
+		}
+	}
+	if b.Kind == 0 {
+		return Binding{}, fmt.Errorf("binding %q: unknown kind %q", s, s[:i])
+	}
+
+	code := s[i+1:]
+	if b.Kind == BK_PADAXIS {
+		switch {
+		case strings.HasSuffix(code, "-"):
+			b.Dir = -1
+		case strings.HasSuffix(code, "+"):
+			b.Dir = 1
+		default:
+			return Binding{}, fmt.Errorf("binding %q: axis needs a + or -", s)
+		}
+		code = code[:len(code)-1]
+	}
+
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 0 {
+		return Binding{}, fmt.Errorf("binding %q: bad code %q", s, code)
+	}
+	b.Code = n
+	return b, nil
+}
+
+// axes go from -32768 to 32767, triggers only from 0. sticks have to be pushed
+// past the deadzone before they do anything, since they never quite sit still
+// in the middle, and then past AXIS_PRESS out of 255 to count as held
+const (
+	AXIS_MAX                 = 32767
+	AXIS_PRESS               = 64
+	DEFAULT_STICK_DEADZONE   = 8000
+	DEFAULT_TRIGGER_DEADZONE = 3000
+)
+
+// InputMap is the bindings for every action. an action can have any number of
+// them and goes off when any one of them does
+type InputMap [NUM_ACTIONS][]Binding
+
+func DefaultInputMap() InputMap {
+	var m InputMap
+	m[ACT_LEFT] = []Binding{{Kind: BK_KEY, Code: 80}, {Kind: BK_PADBUTTON, Code: 13}, {Kind: BK_PADAXIS, Code: 0, Dir: -1}}
+	m[ACT_RIGHT] = []Binding{{Kind: BK_KEY, Code: 79}, {Kind: BK_PADBUTTON, Code: 14}, {Kind: BK_PADAXIS, Code: 0, Dir: 1}}
+	m[ACT_UP] = []Binding{{Kind: BK_KEY, Code: 82}, {Kind: BK_PADBUTTON, Code: 11}, {Kind: BK_PADAXIS, Code: 1, Dir: -1}}
+	m[ACT_DOWN] = []Binding{{Kind: BK_KEY, Code: 81}, {Kind: BK_PADBUTTON, Code: 12}, {Kind: BK_PADAXIS, Code: 1, Dir: 1}}
+	m[ACT_JUMP] = []Binding{{Kind: BK_KEY, Code: 44}, {Kind: BK_PADBUTTON, Code: 0}}                              // space, a
+	m[ACT_SHOOT] = []Binding{{Kind: BK_KEY, Code: 224}, {Kind: BK_MOUSE, Code: 1}, {Kind: BK_PADBUTTON, Code: 2}} // left ctrl, x
+	m[ACT_POGO] = []Binding{{Kind: BK_KEY, Code: 226}, {Kind: BK_MOUSE, Code: 3}, {Kind: BK_PADBUTTON, Code: 1}}  // left alt, b
+	m[ACT_QUICKSAVE] = []Binding{{Kind: BK_KEY, Code: 62}}                                                        // f5
+	m[ACT_QUICKLOAD] = []Binding{{Kind: BK_KEY, Code: 66}}                                                        // f9
+	m[ACT_REBIND] = []Binding{{Kind: BK_KEY, Code: 59}}                                                           // f2
+	m[ACT_PAUSE] = []Binding{{Kind: BK_KEY, Code: 19}, {Kind: BK_PADBUTTON, Code: 6}}                             // p, start
+	m[ACT_SLOWMO] = []Binding{{Kind: BK_KEY, Code: 16}}                                                           // m
+	m[ACT_ZOOMIN] = []Binding{{Kind: BK_KEY, Code: 46}}                                                           // =
+	m[ACT_ZOOMOUT] = []Binding{{Kind: BK_KEY, Code: 45}}                                                          // -
+	return m
+}
+
+// read bindings from a file, which is an object of binding lists by action
+// name. actions the file doesn't mention keep their default bindings
+func LoadInputMap(fname string) (InputMap, error) {
+	m := DefaultInputMap()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	var defs map[string][]string
+	if err := json.NewDecoder(f).Decode(&defs); err != nil {
+		return m, fmt.Errorf("%s: %s", fname, err)
+	}
+
+	for name, binds := range defs {
+		act := NUM_ACTIONS
+		for a, an := range actionNames {
+			if an == name {
+				act = Action(a)
+			}
+		}
+		if act == NUM_ACTIONS {
+			return m, fmt.Errorf("%s: unknown action %q", fname, name)
+		}
+
+		m[act] = nil
+		for _, s := range binds {
+			b, err := ParseBinding(s)
+			if err != nil {
+				return m, fmt.Errorf("%s: %s", fname, err)
+			}
+			m[act] = append(m[act], b)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *InputMap) Save(fname string) error {
+	defs := make(map[string][]string)
+	for a, binds := range m {
+		list := []string{}
+		for _, b := range binds {
+			list = append(list, b.String())
+		}
+		defs[actionNames[a]] = list
+	}
+
+	// json sorts the keys, so saving the same bindings always writes the same file
+	data, err := json.MarshalIndent(defs, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(data, '\n'), 0644)
+}
+
+// add a binding to an action, taking it away from any other action first so
+// one input doesn't do two things
+func (m *InputMap) Bind(act Action, b Binding) {
+	m.Unbind(b)
+	m[act] = append(m[act], b)
+}
+
+// take a binding away from whatever it's bound to
+func (m *InputMap) Unbind(b Binding) {
+	for a := range m {
+		binds := m[a][:0]
+		for _, have := range m[a] {
+			if have != b {
+				binds = append(binds, have)
+			}
+		}
+		m[a] = binds
+	}
+}
+
+// the actions a binding sets off
+func (m *InputMap) actions(b Binding) []Action {
+	var acts []Action
+	for a, binds := range m {
+		for _, have := range binds {
+			if have == b {
+				acts = append(acts, Action(a))
+			}
+		}
+	}
+	return acts
+}
+
+const (
+	NUM_KEYS       = 512
+	NUM_MOUSE      = 8
+	NUM_PADBUTTONS = 32
+	NUM_PADAXES    = 6 // two sticks then two triggers
+	PAD_TRIGGERS   = 4 // the first trigger axis
+)
+
+// Input follows the state of every device and turns it into actions. raw
+// inputs come in as they happen, then Update works out what each action did
+// since the last Update. a press and release that both happen in between
+// still counts as a press, so quick taps aren't lost. bindings don't say which
+// gamepad they're on, any of them will do
+type Input struct {
+	Map             InputMap
+	StickDeadzone   int // how far a stick moves any way from the middle before it counts
+	TriggerDeadzone int
+
+	keys       [NUM_KEYS]bool
+	buttons    [NUM_MOUSE]bool
+	padButtons [MAX_PADS][NUM_PADBUTTONS]bool
+	padAxes    [MAX_PADS][NUM_PADAXES]int
+	mouse      Vector // on the screen
+
+	tapped   [NUM_ACTIONS]bool // pressed at some point since the last Update
+	held     [NUM_ACTIONS]bool
+	pressed  [NUM_ACTIONS]bool
+	released [NUM_ACTIONS]bool
+
+	capture  bool
+	captured func(Binding)
+	ignore   Binding // what was captured, until it's let go
+}
+
+func NewInput(m InputMap) *Input {
+	return &Input{Map: m, StickDeadzone: DEFAULT_STICK_DEADZONE, TriggerDeadzone: DEFAULT_TRIGGER_DEADZONE}
+}
+
+func (in *Input) Key(code int, down bool) {
+	if code >= 0 && code < NUM_KEYS {
+		in.keys[code] = down
+		in.changed(Binding{Kind: BK_KEY, Code: code}, down)
+	}
+}
+
+func (in *Input) MouseButton(button int, down bool) {
+	if button >= 0 && button < NUM_MOUSE {
+		in.buttons[button] = down
+		in.changed(Binding{Kind: BK_MOUSE, Code: button}, down)
+	}
+}
+
+func (in *Input) MouseMove(pos Vector) {
+	in.mouse = pos
+}
+
+// where the mouse is on the screen
+func (in *Input) Mouse() Vector {
+	return in.mouse
+}
+
+func (in *Input) PadButton(pad, button int, down bool) {
+	if pad >= 0 && pad < MAX_PADS && button >= 0 && button < NUM_PADBUTTONS {
+		in.padButtons[pad][button] = down
+		in.changed(Binding{Kind: BK_PADBUTTON, Code: button}, down)
+	}
+}
+
+func (in *Input) PadAxis(pad, axis int, value int) {
+	if pad < 0 || pad >= MAX_PADS || axis < 0 || axis >= NUM_PADAXES {
+		return
+	}
+
+	// moving one half of a stick changes how far the other half counts as
+	// pushed, so both are checked for going past AXIS_PRESS
+	axes := []int{axis}
+	if axis < PAD_TRIGGERS {
+		axes = []int{axis &^ 1, axis | 1}
+	}
+	var old [2]int
+	for i, a := range axes {
+		old[i] = in.axis(pad, a)
+	}
+	in.padAxes[pad][axis] = value
+	for i, a := range axes {
+		now := in.axis(pad, a)
+		for _, dir := range []int{-1, 1} {
+			was, is := old[i]*dir >= AXIS_PRESS, now*dir >= AXIS_PRESS
+			if was != is {
+				in.changed(Binding{Kind: BK_PADAXIS, Code: a, Dir: dir}, is)
+			}
+		}
+	}
+}
+
+// forget a gamepad that was unplugged, so nothing it was holding stays held
+func (in *Input) PadRemoved(pad int) {
+	if pad >= 0 && pad < MAX_PADS {
+		in.padButtons[pad] = [NUM_PADBUTTONS]bool{}
+		in.padAxes[pad] = [NUM_PADAXES]int{}
+	}
+}
+
+// let go of everything, for when the window loses focus and releases would
+// never arrive
+func (in *Input) Clear() {
+	in.keys = [NUM_KEYS]bool{}
+	in.buttons = [NUM_MOUSE]bool{}
+	in.padButtons = [MAX_PADS][NUM_PADBUTTONS]bool{}
+	in.padAxes = [MAX_PADS][NUM_PADAXES]int{}
+}
+
+// how far a gamepad axis is pushed once the deadzone is taken off, from -255
+// to 255. the deadzone for a stick is a circle rather than a square, so
+// pushing it diagonally doesn't feel different from pushing it straight, and
+// the rest of the way out is stretched so the edge of the deadzone is zero
+func (in *Input) axis(pad, axis int) int {
+	raw := &in.padAxes[pad]
+	if axis >= PAD_TRIGGERS {
+		return deadzone(raw[axis], in.TriggerDeadzone)
+	}
+
+	x, y := float64(raw[axis&^1]), float64(raw[axis|1])
+	dist := math.Sqrt(x*x + y*y)
+	if dist <= float64(in.StickDeadzone) {
+		return 0
+	}
+	scale := math.Min(1, (dist-float64(in.StickDeadzone))/float64(AXIS_MAX-in.StickDeadzone))
+	return int(float64(raw[axis]) / dist * scale * 255)
+}
+
+func deadzone(v, dz int) int {
+	if v <= dz {
+		return 0
+	}
+	return min(255, (v-dz)*255/(AXIS_MAX-dz))
+}
+
+// hand the next input that gets pressed to fn instead of setting off any
+// actions with it, for picking what to bind an action to
+func (in *Input) Capture(fn func(Binding)) {
+	in.capture = true
+	in.captured = fn
+}
+
+func (in *Input) changed(b Binding, down bool) {
+	if !down {
+		if b == in.ignore {
+			in.ignore = Binding{}
+		}
+		return
+	}
+	if in.capture {
+		fn := in.captured
+		in.capture, in.captured = false, nil
+		in.ignore = b
+		fn(b)
+		return
+	}
+	for _, a := range in.Map.actions(b) {
+		in.tapped[a] = true
+	}
+}
+
+// how far a binding is pushed, from 0 to 255. only axes go in between
+func (in *Input) value(b Binding) int {
+	if b == in.ignore {
+		return 0
+	}
+	switch b.Kind {
+	case BK_KEY:
+		return btoi(b.Code < NUM_KEYS && in.keys[b.Code]) * 255
+	case BK_MOUSE:
+		return btoi(b.Code < NUM_MOUSE && in.buttons[b.Code]) * 255
+	case BK_PADBUTTON:
+		v := 0
+		for pad := range in.padButtons {
+			v = max(v, btoi(b.Code < NUM_PADBUTTONS && in.padButtons[pad][b.Code])*255)
+		}
+		return v
+	case BK_PADAXIS:
+		if b.Code >= NUM_PADAXES {
+			return 0
+		}
+		v := 0
+		for pad := range in.padAxes {
+			v = max(v, in.axis(pad, b.Code)*b.Dir)
+		}
+		return v
+	}
+	return 0
+}
+
+// how far an action is pushed, from 0 to 255. the furthest of its bindings wins
+func (in *Input) Value(a Action) int {
+	v := 0
+	for _, b := range in.Map[a] {
+		v = max(v, in.value(b))
+	}
+	return v
+}
+
+// work out what every action did since the last Update
+func (in *Input) Update() {
+	for a := Action(0); a < NUM_ACTIONS; a++ {
+		was := in.held[a]
+		in.held[a] = in.Value(a) >= AXIS_PRESS
+		in.pressed[a] = (in.held[a] && !was) || in.tapped[a]
+		in.released[a] = !in.held[a] && (was || in.tapped[a])
+		in.tapped[a] = false
+	}
+}
+
+func (in *Input) Held(a Action) bool     { return in.held[a] }
+func (in *Input) Pressed(a Action) bool  { return in.pressed[a] }
+func (in *Input) Released(a Action) bool { return in.released[a] }
+
+// the command for the simulation from the actions as of the last Update. a
+// button that was tapped and let go since then is still sent as held so the
+// simulation gets to see it. directions only count once they're held, so a
+// stick pushed mostly sideways doesn't also hold down a little
+func (in *Input) Command() UserCommand {
+	dir := func(a Action) int {
+		if !in.held[a] {
+			return 0
+		}
+		return in.Value(a)
+	}
+	cmd := UserCommand{
+		Up:    dir(ACT_UP),
+		Down:  dir(ACT_DOWN),
+		Left:  dir(ACT_LEFT),
+		Right: dir(ACT_RIGHT),
+	}
+	buttons := []struct {
+		act Action
+		bit uint32
+	}{
+		{ACT_JUMP, BT_JUMP},
+		{ACT_SHOOT, BT_SHOOT},
+		{ACT_POGO, BT_POGO},
+	}
+	for _, b := range buttons {
+		if in.held[b.act] || in.pressed[b.act] {
+			cmd.Buttons |= b.bit
+		}
+	}
+	return cmd
+}
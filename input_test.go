@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// press and let go of a key, with an Update after each like pollInput does
+func tapKey(in *Input, code int) {
+	in.Key(code, true)
+	in.Update()
+	in.Key(code, false)
+	in.Update()
+}
+
+// what an action reads as after each Update, for keys going down and up
+// between them
+func TestInputEdges(t *testing.T) {
+	const space = 44
+	steps := []struct {
+		name                    string
+		keys                    []bool // space going down or up, in order, before the Update
+		held, pressed, released bool
+	}{
+		{"press", []bool{true}, true, true, false},
+		{"hold", nil, true, false, false},
+		{"still holding", nil, true, false, false},
+		{"let go", []bool{false}, false, false, true},
+		{"nothing", nil, false, false, false},
+
+		// too quick for an Update to see it down, but it still counts as both
+		{"tap", []bool{true, false}, false, true, true},
+		{"after a tap", nil, false, false, false},
+
+		// let go and down again while held is a new press
+		{"press again", []bool{true}, true, true, false},
+		{"let go and press", []bool{false, true}, true, true, false},
+		{"let go at last", []bool{false}, false, false, true},
+	}
+
+	in := NewInput(DefaultInputMap())
+	for _, step := range steps {
+		for _, down := range step.keys {
+			in.Key(space, down)
+		}
+		in.Update()
+		held, pressed, released := in.Held(ACT_JUMP), in.Pressed(ACT_JUMP), in.Released(ACT_JUMP)
+		if held != step.held || pressed != step.pressed || released != step.released {
+			t.Errorf("%s: held %v pressed %v released %v, want %v %v %v",
+				step.name, held, pressed, released, step.held, step.pressed, step.released)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bindings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.Mkdir("base", 0755)
+
+	const space, z, f2 = 44, 29, 59
+	s := &GameScene{input: NewInput(DefaultInputMap())}
+	in := s.input
+
+	// f2, then space which is jump, then z to jump with instead
+	for _, code := range []int{f2, space, z} {
+		in.Key(code, true)
+		in.Update()
+		s.rebind()
+		if in.Pressed(ACT_JUMP) {
+			t.Fatal("keys pressed while rebinding set off jump")
+		}
+		in.Key(code, false)
+		in.Update()
+	}
+
+	tapKey(in, space)
+	if in.Pressed(ACT_JUMP) {
+		t.Error("space still jumps")
+	}
+	in.Key(z, true)
+	in.Update()
+	if !in.Pressed(ACT_JUMP) {
+		t.Error("z doesn't jump")
+	}
+
+	m, err := LoadInputMap("base/" + BINDINGS_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m[ACT_JUMP][len(m[ACT_JUMP])-1] != (Binding{Kind: BK_KEY, Code: z}) {
+		t.Errorf("saved jump bindings are %v", m[ACT_JUMP])
+	}
+}
+
+// binding something takes it off whatever it did before
+func TestBindMoves(t *testing.T) {
+	m := DefaultInputMap()
+	space := Binding{Kind: BK_KEY, Code: 44}
+	m.Bind(ACT_SHOOT, space)
+	if acts := m.actions(space); len(acts) != 1 || acts[0] != ACT_SHOOT {
+		t.Errorf("space does %v", acts)
+	}
+}
+
+func TestRebindUnbound(t *testing.T) {
+	s := &GameScene{input: NewInput(DefaultInputMap())}
+	in := s.input
+
+	in.Key(59, true)
+	in.Update()
+	s.rebind()
+	in.Key(59, false)
+
+	// nothing does q, so there's nothing to rebind and the next key is just a key
+	tapKey(in, 20)
+	if m := DefaultInputMap(); len(in.Map[ACT_JUMP]) != len(m[ACT_JUMP]) {
+		t.Errorf("jump bindings changed to %v", in.Map[ACT_JUMP])
+	}
+	in.Key(44, true)
+	in.Update()
+	if !in.Pressed(ACT_JUMP) {
+		t.Error("space stopped jumping")
+	}
+}
+
+// in slow motion most wakes don't run a step, and a tap that came and went on
+// one of those still has to get to the next step that does run
+func TestTapBetweenSteps(t *testing.T) {
+	s := newTestScene(t, testObject("player_start", 16, 32))
+	s.player().Inv.Pogo = true
+	s.update(UserCommand{})
+
+	s.acc = s.step / 4
+	if err := s.runSteps(UserCommand{Buttons: BT_POGO}); err != nil {
+		t.Fatal(err)
+	}
+	if s.state.Tick != 1 {
+		t.Fatal("stepped without enough time built up")
+	}
+	s.acc = s.step
+	if err := s.runSteps(UserCommand{}); err != nil {
+		t.Fatal(err)
+	}
+	if s.player().State != PS_POGO {
+		t.Fatal("tap between steps was lost")
+	}
+
+	// and only the once
+	s.acc = s.step
+	s.runSteps(UserCommand{})
+	s.acc = s.step
+	s.runSteps(UserCommand{})
+	if s.player().State != PS_POGO {
+		t.Error("tap was used more than once")
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import "time"
+
+// the entities from Commander Keen. speeds are in pixels per step and times
+// are in steps
+const (
+	PLAYER_SPEED   = Fixed(1 << FIXED_SHIFT)
+	PLAYER_ACCEL   = Fixed(1 << (FIXED_SHIFT - 3))
+	PLAYER_JUMP    = Fixed(7 << (FIXED_SHIFT - 1))
+	PLAYER_GRAVITY = Fixed(1 << (FIXED_SHIFT - 3))
+	PLAYER_MAXFALL = Fixed(3 << FIXED_SHIFT)
+
+	POGO_BOUNCE = Fixed(5 << (FIXED_SHIFT - 1)) // bounce with nothing held
+	POGO_JUMP   = Fixed(9 << (FIXED_SHIFT - 1)) // bounce while holding up
+
+	SHOT_SPEED = Fixed(3 << FIXED_SHIFT)
+	SHOT_LIFE  = 120
+
+	YORP_SPEED     = Fixed(3 << (FIXED_SHIFT - 3))
+	YORP_HOP       = Fixed(3 << (FIXED_SHIFT - 1))
+	YORP_HOP_ODDS  = 90 // one in this many steps while walking
+	YORP_PUSH      = Fixed(3 << (FIXED_SHIFT - 1))
+	YORP_BOUNCE    = Fixed(5 << (FIXED_SHIFT - 1))
+	YORP_WALK_TIME = 240 // up to twice this
+	YORP_LOOK_TIME = 90
+	YORP_STUN_TIME = 480
+	YORP_STOMP     = Fixed(1 << (FIXED_SHIFT - 2)) // how much landing on one shakes the camera
+
+	PATPAT_TIME = 240 // how long it stays open or closed
+
+	RAYGUN_AMMO = 5
+)
+
+// player states
+const (
+	PS_WALK uint8 = iota
+	PS_POGO
+)
+
+// yorp states
+const (
+	YS_LOOK uint8 = iota
+	YS_WALK
+	YS_STUNNED
+)
+
+// patpat states
+const (
+	PP_CLOSED uint8 = iota
+	PP_OPEN
+)
+
+// collectables by Entity.Variant. the first one is what the level gets when it
+// doesn't say. the name is also the animation it's drawn with
+var collectables = [...]struct {
+	Name   string
+	Points int32
+}{
+	{"lollipop", 100},
+	{"soda", 200},
+	{"pizza", 500},
+	{"book", 1000},
+	{"teddy", 5000},
+}
+
+func init() {
+	entityClasses[ET_PLAYER] = EntityClass{Name: "player", Think: thinkPlayer}
+	entityClasses[ET_SHOT] = EntityClass{Name: "shot", Think: thinkShot, Touch: touchShot}
+	entityClasses[ET_YORP] = EntityClass{Name: "yorp", Think: thinkYorp, Touch: touchYorp}
+	entityClasses[ET_PATPAT] = EntityClass{Name: "patpat", Think: thinkPatpat, Touch: touchPatpat}
+	entityClasses[ET_COLLECTABLE] = EntityClass{
+		Name: "collectable",
+		Touch: pickup(func(ent, player *Entity) {
+			player.Inv.Score += collectables[ent.Variant].Points
+		}),
+		Variants: len(collectables),
+	}
+	entityClasses[ET_RAYGUN] = EntityClass{
+		Name: "raygun",
+		Touch: pickup(func(ent, player *Entity) {
+			player.Inv.Ammo += RAYGUN_AMMO
+		}),
+	}
+	entityClasses[ET_POGO] = EntityClass{
+		Name: "pogo",
+		Touch: pickup(func(ent, player *Entity) {
+			player.Inv.Pogo = true
+		}),
+	}
+
+	RegisterSpawn("player_start", spawnPlayer)
+	RegisterSpawn("EntityPlayer", spawnPlayer)
+	RegisterSpawn("EntityYorp", spawnYorp)
+	RegisterSpawn("EntityPatpat", spawnPatpat)
+	RegisterSpawn("EntityCollectable", spawnCollectable)
+	RegisterSpawn("EntityRaygun", spawnItem(ET_RAYGUN, "raygun"))
+	RegisterSpawn("EntityPogo", spawnItem(ET_POGO, "pogo"))
+}
+
+// the object's size if the map gave it one, otherwise def
+func spawnSize(obj *SpawnObject, def Size) Size {
+	if obj.Size != (Size{}) {
+		return obj.Size
+	}
+	return def
+}
+
+// 1 if other is to the right of ent's middle, otherwise -1
+func sideOf(ent, other *Entity) int32 {
+	if other.Pos.X+Fx(int(other.Size.W))/2 > ent.Pos.X+Fx(int(ent.Size.W))/2 {
+		return 1
+	}
+	return -1
+}
+
+func spawnPlayer(w *World, obj *SpawnObject) *Entity {
+	ent := w.State.Entities.Spawn(ET_PLAYER)
+	if ent == nil {
+		return nil
+	}
+
+	ent.Pos = obj.Pos
+	ent.Size = spawnSize(obj, Size{16, 32})
+	ent.Dir = 1
+	ent.Body = Body{Collide: true, Gravity: PLAYER_GRAVITY, MaxFall: PLAYER_MAXFALL}
+	ent.Group = GROUP_PLAYER
+	ent.Mask = GROUP_ENEMY | GROUP_PICKUP
+	w.Sprites.Play(&ent.Anim, "player", "stand")
+
+	// the first player in the level is the one being controlled
+	if w.State.LocalEnt == NO_ENTITY {
+		w.State.LocalEnt = ent.Id
+	}
+	return ent
+}
+
+func thinkPlayer(w *World, ent *Entity) {
+	cmd := w.Command(ent)
+	pressed := cmd.Buttons &^ ent.Held
+	ent.Held = cmd.Buttons
+
+	// speed up towards the direction being held, or slow down to a stop
+	want := (Fx(cmd.Right-cmd.Left) / 255).Mul(PLAYER_SPEED)
+	ent.Vel.X = ent.Vel.X.Approach(want, PLAYER_ACCEL)
+	if want > 0 {
+		ent.Dir = 1
+	} else if want < 0 {
+		ent.Dir = -1
+	}
+
+	if pressed&BT_POGO != 0 && ent.Inv.Pogo {
+		if ent.State == PS_POGO {
+			ent.State = PS_WALK
+		} else {
+			ent.State = PS_POGO
+		}
+	}
+
+	jump := cmd.Up > 0 || cmd.Buttons&BT_JUMP != 0
+
+	switch ent.State {
+	case PS_POGO:
+		// bounce every time we land, higher if up or jump is held
+		if ent.Body.OnGround() {
+			ent.Vel.Y = -POGO_BOUNCE
+			if jump {
+				ent.Vel.Y = -POGO_JUMP
+			}
+		}
+	default:
+		// jump off the ground, or hold down to drop through one way platforms
+		if jump && ent.Body.OnGround() {
+			ent.Vel.Y = -PLAYER_JUMP
+		}
+		ent.Body.DropThrough = cmd.Down > 0
+	}
+
+	shot := false
+	if pressed&BT_SHOOT != 0 && ent.Inv.Ammo > 0 {
+		fireShot(w, ent)
+		ent.Inv.Ammo--
+		shot = true
+	}
+
+	// move based on velocity, stopping at anything solid in the map
+	w.Collision.Move(ent)
+
+	// the player sheet faces right
+	ent.Anim.Flip = ent.Dir < 0
+	switch {
+	case shot:
+		w.Sprites.Play(&ent.Anim, "player", "shoot")
+	case w.Sprites.Playing(&ent.Anim, "shoot") && !ent.Anim.Done:
+		// let the shot finish showing
+	case ent.State == PS_POGO:
+		w.Sprites.Play(&ent.Anim, "player", "pogo")
+	case !ent.Body.OnGround() && ent.Vel.Y < 0:
+		w.Sprites.Play(&ent.Anim, "player", "jump")
+	case !ent.Body.OnGround():
+		w.Sprites.Play(&ent.Anim, "player", "fall")
+	case ent.Vel.X != 0:
+		w.Sprites.Play(&ent.Anim, "player", "walk")
+	default:
+		w.Sprites.Play(&ent.Anim, "player", "stand")
+	}
+}
+
+func fireShot(w *World, from *Entity) {
+	shot := w.State.Entities.Spawn(ET_SHOT)
+	if shot == nil {
+		return
+	}
+
+	shot.Size = Size{8, 4}
+	shot.Pos.Y = from.Pos.Y + Fx(int(from.Size.H-shot.Size.H)/2)
+	if from.Dir > 0 {
+		shot.Pos.X = from.Pos.X + Fx(int(from.Size.W))
+	} else {
+		shot.Pos.X = from.Pos.X - Fx(int(shot.Size.W))
+	}
+	shot.Dir = from.Dir
+	shot.Vel.X = Fixed(from.Dir) * SHOT_SPEED
+	shot.Timer = SHOT_LIFE
+	shot.Body = Body{Collide: true}
+	shot.Group = GROUP_SHOT
+	shot.Mask = GROUP_ENEMY
+	shot.Color = RGBA{255, 255, 85, 255}
+}
+
+// shots fly straight until they hit something
+func thinkShot(w *World, ent *Entity) {
+	w.Collision.Move(ent)
+
+	ent.Timer--
+	if ent.Body.Contacts&CT_WALL != 0 || ent.Timer <= 0 {
+		w.State.Entities.Despawn(ent.Id)
+	}
+}
+
+func touchShot(w *World, ent *Entity, other *Entity, phase ContactPhase) {
+	if phase == CP_ENTER && other.Group&GROUP_ENEMY != 0 {
+		w.State.Entities.Despawn(ent.Id)
+	}
+}
+
+func spawnYorp(w *World, obj *SpawnObject) *Entity {
+	ent := w.State.Entities.Spawn(ET_YORP)
+	if ent == nil {
+		return nil
+	}
+
+	ent.Pos = obj.Pos
+	ent.Size = spawnSize(obj, Size{16, 24})
+	ent.Dir = -1
+	ent.State = YS_LOOK
+	ent.Timer = YORP_LOOK_TIME
+	ent.Body = Body{Collide: true, Gravity: PLAYER_GRAVITY, MaxFall: PLAYER_MAXFALL}
+	ent.Group = GROUP_ENEMY
+	ent.Mask = GROUP_PLAYER | GROUP_SHOT
+	ent.Color = RGBA{85, 255, 85, 255}
+	return ent
+}
+
+// yorps look around for a bit, then wander off towards the player hopping
+// every so often, and turn around when they walk into a wall
+func thinkYorp(w *World, ent *Entity) {
+	ent.Timer--
+
+	switch ent.State {
+	case YS_LOOK, YS_STUNNED:
+		ent.Vel.X = 0
+		if ent.Timer <= 0 {
+			ent.State = YS_WALK
+			ent.Timer = int32(YORP_WALK_TIME + w.State.Random(YORP_WALK_TIME))
+			if player := w.State.Entities.Get(w.State.LocalEnt); player != nil {
+				ent.Dir = sideOf(ent, player)
+			}
+		}
+	case YS_WALK:
+		ent.Vel.X = Fixed(ent.Dir) * YORP_SPEED
+		if ent.Body.OnGround() && w.State.Random(YORP_HOP_ODDS) == 0 {
+			ent.Vel.Y = -YORP_HOP
+		}
+		if ent.Timer <= 0 {
+			ent.State = YS_LOOK
+			ent.Timer = YORP_LOOK_TIME
+		}
+	}
+
+	w.Collision.Move(ent)
+	if ent.Body.Contacts&CT_WALL != 0 {
+		ent.Dir = -ent.Dir
+	}
+
+	if ent.State == YS_STUNNED {
+		ent.Color = RGBA{40, 128, 40, 255}
+	} else {
+		ent.Color = RGBA{85, 255, 85, 255}
+	}
+}
+
+func touchYorp(w *World, ent *Entity, other *Entity, phase ContactPhase) {
+	if phase == CP_EXIT {
+		return
+	}
+
+	switch other.Type {
+	case ET_SHOT:
+		if phase == CP_ENTER {
+			w.State.Entities.Despawn(ent.Id)
+		}
+	case ET_PLAYER:
+		// landing on a yorp's head knocks it out for a while. otherwise it
+		// shoves the player out of the way
+		feet := other.Pos.Y + Fx(int(other.Size.H))
+		if other.Vel.Y > 0 && feet < ent.Pos.Y+Fx(int(ent.Size.H))/2 {
+			ent.State = YS_STUNNED
+			ent.Timer = YORP_STUN_TIME
+			other.Vel.Y = -YORP_BOUNCE
+			w.State.Camera.AddShake(YORP_STOMP)
+			w.Rumble(other, 0.5, 150*time.Millisecond)
+		} else if ent.State != YS_STUNNED {
+			other.Vel.X = Fixed(sideOf(ent, other)) * YORP_PUSH
+			if phase == CP_ENTER {
+				w.Rumble(other, 0.25, 100*time.Millisecond)
+			}
+		}
+	}
+}
+
+func spawnPatpat(w *World, obj *SpawnObject) *Entity {
+	ent := w.State.Entities.Spawn(ET_PATPAT)
+	if ent == nil {
+		return nil
+	}
+
+	ent.Pos = obj.Pos
+	ent.Size = spawnSize(obj, Size{16, 16})
+	ent.State = PP_CLOSED
+	if obj.Props.Bool("start_open", false) {
+		ent.State = PP_OPEN
+	}
+	ent.Timer = PATPAT_TIME
+	ent.Mask = GROUP_PLAYER | GROUP_ENEMY | GROUP_SHOT
+	return ent
+}
+
+// patpats are doors that swing open and shut on their own
+func thinkPatpat(w *World, ent *Entity) {
+	ent.Timer--
+	if ent.Timer <= 0 {
+		if ent.State == PP_OPEN {
+			ent.State = PP_CLOSED
+		} else {
+			ent.State = PP_OPEN
+		}
+		ent.Timer = PATPAT_TIME
+	}
+
+	if ent.State == PP_OPEN {
+		ent.Color = RGBA{85, 0, 85, 255}
+	} else {
+		ent.Color = RGBA{170, 0, 170, 255}
+	}
+}
+
+// a shut door stops shots, and pushes anything else back out the side it came in
+func touchPatpat(w *World, ent *Entity, other *Entity, phase ContactPhase) {
+	if phase == CP_EXIT || ent.State != PP_CLOSED {
+		return
+	}
+
+	if other.Type == ET_SHOT {
+		w.State.Entities.Despawn(other.Id)
+		return
+	}
+
+	if sideOf(ent, other) > 0 {
+		other.Pos.X = ent.Pos.X + Fx(int(ent.Size.W))
+		if other.Vel.X < 0 {
+			other.Vel.X = 0
+		}
+	} else {
+		other.Pos.X = ent.Pos.X - Fx(int(other.Size.W))
+		if other.Vel.X > 0 {
+			other.Vel.X = 0
+		}
+	}
+}
+
+func spawnCollectable(w *World, obj *SpawnObject) *Entity {
+	ent := spawnItem(ET_COLLECTABLE, "")(w, obj)
+	if ent == nil {
+		return nil
+	}
+
+	name := obj.Props.String("type", "")
+	for i := range collectables {
+		if collectables[i].Name == name {
+			ent.Variant = uint8(i)
+		}
+	}
+	w.Sprites.Play(&ent.Anim, "items", collectables[ent.Variant].Name)
+	return ent
+}
+
+// make a SpawnFunc for something that floats in place waiting to be picked up
+func spawnItem(typ EntityType, anim string) SpawnFunc {
+	return func(w *World, obj *SpawnObject) *Entity {
+		ent := w.State.Entities.Spawn(typ)
+		if ent == nil {
+			return nil
+		}
+
+		ent.Pos = obj.Pos
+		ent.Size = spawnSize(obj, Size{16, 16})
+		ent.Group = GROUP_PICKUP
+		w.Sprites.Play(&ent.Anim, "items", anim)
+		return ent
+	}
+}
+
+// make a Touch that gives something to the first player to touch an item and
+// then removes the item
+func pickup(give func(ent, player *Entity)) func(w *World, ent *Entity, other *Entity, phase ContactPhase) {
+	return func(w *World, ent *Entity, other *Entity, phase ContactPhase) {
+		if phase != CP_ENTER || other.Type != ET_PLAYER {
+			return
+		}
+
+		give(ent, other)
+		w.State.Entities.Despawn(ent.Id)
+	}
+}
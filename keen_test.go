@@ -0,0 +1,326 @@
+package main
+
+import (
+	"testing"
+
+	"./tmx"
+)
+
+const (
+	TEST_LEVEL_WIDTH  = 40
+	TEST_LEVEL_HEIGHT = 20
+	TEST_FLOOR        = (TEST_LEVEL_HEIGHT - 1) * 16 // top of the bottom row, which is solid
+)
+
+// a scene for a flat room with the objects in it, set up without an engine
+// or sprites so it can be stepped through update
+func newTestScene(t testing.TB, objs ...SpawnObject) *GameScene {
+	cm := &CollisionMap{
+		Width:    TEST_LEVEL_WIDTH,
+		Height:   TEST_LEVEL_HEIGHT,
+		TileSize: Fx(16),
+		Tiles:    make([]TileCollision, TEST_LEVEL_WIDTH*TEST_LEVEL_HEIGHT),
+	}
+	for col := 0; col < TEST_LEVEL_WIDTH; col++ {
+		cm.Tiles[(TEST_LEVEL_HEIGHT-1)*TEST_LEVEL_WIDTH+col] = TC_SOLID
+	}
+
+	level := &Level{
+		Map:       tmx.Map{Width: TEST_LEVEL_WIDTH, Height: TEST_LEVEL_HEIGHT, TileWidth: 16, TileHeight: 16},
+		Collision: cm,
+		Objects:   objs,
+	}
+	s := &GameScene{Seed: 1}
+	if errs := s.setup(level); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return s
+}
+
+// an object standing on the floor
+func testObject(typ string, x, h int) SpawnObject {
+	return SpawnObject{Type: typ, Pos: Vec2{Fx(x), Fx(TEST_FLOOR - h)}, Props: Properties{}}
+}
+
+func stepScene(s *GameScene, cmd UserCommand, n int) {
+	for i := 0; i < n; i++ {
+		s.update(cmd)
+	}
+}
+
+func (s *GameScene) player() *Entity {
+	return s.state.Entities.Get(s.state.LocalEnt)
+}
+
+// the first live entity of a type, or nil
+func (s *GameScene) find(typ EntityType) *Entity {
+	for _, slot := range s.state.Entities.LiveSlots() {
+		if ent := &s.state.Entities.Ents[slot]; ent.Valid && ent.Type == typ {
+			return ent
+		}
+	}
+	return nil
+}
+
+func TestPickups(t *testing.T) {
+	pizza := testObject("EntityCollectable", 60, 16)
+	pizza.Props["type"] = "pizza"
+	s := newTestScene(t,
+		testObject("player_start", 16, 32),
+		pizza,
+		testObject("EntityRaygun", 100, 16),
+		testObject("EntityPogo", 140, 16),
+	)
+
+	stepScene(s, UserCommand{Right: 255}, 240)
+
+	p := s.player()
+	if p.Pos.X.Int() < 160 {
+		t.Fatalf("player only got to %d", p.Pos.X.Int())
+	}
+	if p.Inv.Score != 500 || p.Inv.Ammo != RAYGUN_AMMO || !p.Inv.Pogo {
+		t.Errorf("inventory is %+v after walking over everything", p.Inv)
+	}
+	for _, typ := range []EntityType{ET_COLLECTABLE, ET_RAYGUN, ET_POGO} {
+		if s.find(typ) != nil {
+			t.Errorf("%s is still there after being picked up", entityClasses[typ].Name)
+		}
+	}
+}
+
+func TestShotKillsYorp(t *testing.T) {
+	s := newTestScene(t,
+		testObject("player_start", 16, 32),
+		testObject("EntityYorp", 200, 24),
+	)
+	s.player().Inv.Ammo = 1
+
+	s.update(UserCommand{Buttons: BT_SHOOT})
+	if s.find(ET_SHOT) == nil {
+		t.Fatal("shooting didn't make a shot")
+	}
+	if s.player().Inv.Ammo != 0 {
+		t.Errorf("ammo is %d after shooting the last one", s.player().Inv.Ammo)
+	}
+
+	// holding the button doesn't shoot again, and there's nothing left anyway
+	stepScene(s, UserCommand{Buttons: BT_SHOOT}, 120)
+	if s.find(ET_YORP) != nil {
+		t.Error("yorp survived being shot")
+	}
+	if s.find(ET_SHOT) != nil {
+		t.Error("shot is still flying after hitting the yorp")
+	}
+}
+
+func TestShotWithoutAmmo(t *testing.T) {
+	s := newTestScene(t, testObject("player_start", 16, 32))
+	s.update(UserCommand{Buttons: BT_SHOOT})
+	if s.find(ET_SHOT) != nil {
+		t.Fatal("shot without any ammo")
+	}
+}
+
+func TestStompYorp(t *testing.T) {
+	s := newTestScene(t,
+		SpawnObject{Type: "player_start", Pos: Vec2{Fx(100), Fx(200)}},
+		testObject("EntityYorp", 100, 24),
+	)
+
+	// the yorp stands looking around long enough to be landed on
+	var stunned bool
+	for i := 0; i < YORP_LOOK_TIME && !stunned; i++ {
+		s.update(UserCommand{})
+		stunned = s.find(ET_YORP).State == YS_STUNNED
+	}
+	if !stunned {
+		t.Fatal("landing on the yorp didn't stun it")
+	}
+	if s.player().Vel.Y >= 0 {
+		t.Error("player didn't bounce off the yorp")
+	}
+	if s.state.Camera.Trauma <= 0 {
+		t.Error("stomping didn't shake the camera")
+	}
+	if len(s.world.Rumbles) == 0 {
+		t.Error("stomping didn't rumble")
+	}
+
+	// a stunned yorp doesn't shove the player about
+	yorp := s.find(ET_YORP)
+	stepScene(s, UserCommand{}, 60)
+	if yorp.State != YS_STUNNED || yorp.Vel.X != 0 {
+		t.Error("yorp got up too soon")
+	}
+}
+
+// with no player to head for a yorp keeps the way it was facing, left, until
+// the edge of the level turns it around
+func TestYorpTurnsAtWall(t *testing.T) {
+	s := newTestScene(t, testObject("EntityYorp", 40, 24))
+	yorp := s.find(ET_YORP)
+
+	stepScene(s, UserCommand{}, YORP_LOOK_TIME-1)
+	if yorp.State != YS_LOOK || yorp.Pos.X != Fx(40) {
+		t.Fatal("yorp didn't stand and look around first")
+	}
+	s.update(UserCommand{})
+	if yorp.State != YS_WALK {
+		t.Fatal("yorp didn't start walking")
+	}
+
+	var turned bool
+	for i := 0; i < YORP_WALK_TIME && !turned; i++ {
+		s.update(UserCommand{})
+		turned = yorp.Dir > 0
+	}
+	if !turned {
+		t.Fatalf("yorp didn't turn around, got to %v", yorp.Pos.X.Float())
+	}
+	if yorp.Pos.X != 0 {
+		t.Errorf("yorp turned at %v, not at the wall", yorp.Pos.X.Float())
+	}
+
+	// and heads back the other way
+	stepScene(s, UserCommand{}, 30)
+	if yorp.State == YS_WALK && yorp.Pos.X <= 0 {
+		t.Error("yorp didn't walk away from the wall")
+	}
+}
+
+func TestYorpWandersToPlayer(t *testing.T) {
+	s := newTestScene(t,
+		testObject("player_start", 300, 32),
+		testObject("EntityYorp", 100, 24),
+	)
+	yorp := s.find(ET_YORP)
+
+	stepScene(s, UserCommand{}, YORP_LOOK_TIME)
+	if yorp.State != YS_WALK || yorp.Dir != 1 {
+		t.Fatalf("yorp is in state %d facing %d, want walking right", yorp.State, yorp.Dir)
+	}
+
+	// it walks for a while then stops to look again
+	var walked, looked bool
+	for i := 0; i < 2*YORP_WALK_TIME+1 && !looked; i++ {
+		s.update(UserCommand{})
+		walked = walked || yorp.Pos.X > Fx(100)
+		looked = yorp.State == YS_LOOK
+	}
+	if !walked {
+		t.Error("yorp didn't walk towards the player")
+	}
+	if !looked {
+		t.Error("yorp never stopped walking")
+	}
+}
+
+func TestPatpatOpensAndShuts(t *testing.T) {
+	open := testObject("EntityPatpat", 100, 16)
+	open.Props["start_open"] = "true"
+	s := newTestScene(t, testObject("EntityPatpat", 60, 16), open)
+	shut := s.find(ET_PATPAT)
+	var other *Entity
+	for _, slot := range s.state.Entities.LiveSlots() {
+		if ent := &s.state.Entities.Ents[slot]; ent.Type == ET_PATPAT && ent != shut {
+			other = ent
+		}
+	}
+	if shut.State != PP_CLOSED || other.State != PP_OPEN {
+		t.Fatal("patpats didn't start the way they were placed")
+	}
+
+	stepScene(s, UserCommand{}, PATPAT_TIME-1)
+	if shut.State != PP_CLOSED || other.State != PP_OPEN {
+		t.Fatal("patpats changed too soon")
+	}
+	s.update(UserCommand{})
+	if shut.State != PP_OPEN || other.State != PP_CLOSED {
+		t.Fatal("patpats didn't swap over")
+	}
+	stepScene(s, UserCommand{}, PATPAT_TIME)
+	if shut.State != PP_CLOSED || other.State != PP_OPEN {
+		t.Error("patpats didn't swap back")
+	}
+}
+
+func TestPatpatDoor(t *testing.T) {
+	door := testObject("EntityPatpat", 100, 16)
+	s := newTestScene(t, testObject("player_start", 16, 32), door)
+	s.player().Inv.Ammo = 1
+
+	// a shut door stops shots, and us
+	s.update(UserCommand{Buttons: BT_SHOOT})
+	stepScene(s, UserCommand{}, 30)
+	if s.find(ET_SHOT) != nil {
+		t.Error("shot went through a shut door")
+	}
+	stepScene(s, UserCommand{Right: 255}, 120)
+	if x := s.player().Pos.X; x+Fx(int(s.player().Size.W)) > Fx(100) {
+		t.Errorf("walked into a shut door, got to %v", x.Float())
+	}
+
+	// and lets us through once it opens
+	for s.find(ET_PATPAT).State != PP_OPEN {
+		s.update(UserCommand{Right: 255})
+	}
+	stepScene(s, UserCommand{Right: 255}, 60)
+	if x := s.player().Pos.X; x < Fx(116) {
+		t.Errorf("didn't get through the open door, got to %v", x.Float())
+	}
+}
+
+// how high the player gets over some steps, in pixels above where they started
+func peak(s *GameScene, cmd UserCommand, steps int) int {
+	start := s.player().Pos.Y
+	top := start
+	for i := 0; i < steps; i++ {
+		s.update(cmd)
+		if y := s.player().Pos.Y; y < top {
+			top = y
+		}
+	}
+	return int((start - top).Int())
+}
+
+func TestPogo(t *testing.T) {
+	s := newTestScene(t, testObject("player_start", 16, 32))
+
+	// nothing happens without the pogo stick
+	s.update(UserCommand{Buttons: BT_POGO})
+	if s.player().State != PS_WALK {
+		t.Fatal("got on a pogo stick we don't have")
+	}
+
+	s.player().Inv.Pogo = true
+	s.update(UserCommand{})
+	s.update(UserCommand{Buttons: BT_POGO})
+	if s.player().State != PS_POGO {
+		t.Fatal("didn't get on the pogo stick")
+	}
+
+	low := peak(s, UserCommand{}, 120)
+	high := peak(s, UserCommand{Buttons: BT_JUMP}, 120)
+	if low <= 0 {
+		t.Fatal("pogo doesn't bounce on its own")
+	}
+	if high <= low {
+		t.Errorf("holding jump bounced to %d, no higher than %d", high, low)
+	}
+
+	// pressing it again gets off
+	s.update(UserCommand{Buttons: BT_POGO})
+	if s.player().State != PS_WALK {
+		t.Error("didn't get off the pogo stick")
+	}
+}
+
+func TestRandomZero(t *testing.T) {
+	st := GameState{Rand: 1}
+	if n := st.Random(0); n != 0 {
+		t.Errorf("Random(0) = %d", n)
+	}
+	if n := st.Random(-5); n != 0 {
+		t.Errorf("Random(-5) = %d", n)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"./gamemap"
+	"./tmx"
+)
+
+// Level is a map file loaded for play. Tiled maps are used as they are, and
+// Impact levels are converted to look like one
+type Level struct {
+	Map       tmx.Map
+	Collision *CollisionMap
+	Objects   []SpawnObject
+}
+
+// SpawnObject is a map object or level entity that an entity gets spawned
+// from. Pos and Size are in pixels, and Size is zero if the map didn't
+// give the object one. tile objects that don't spawn anything are drawn as
+// scenery instead
+type SpawnObject struct {
+	Type  string
+	Name  string
+	Pos   Vec2
+	Size  Size
+	Props Properties
+	Tile  *tmx.DecodedTile // what a tile object looks like, nil for anything else
+}
+
+// Properties are the custom properties set on a map object. map files keep
+// them as text, so they're converted when they're asked for, falling back to
+// def if they're missing or don't convert
+type Properties map[string]string
+
+func (p Properties) String(name, def string) string {
+	if v, ok := p[name]; ok {
+		return v
+	}
+	return def
+}
+
+func (p Properties) Int(name string, def int) int {
+	if v, err := strconv.Atoi(p[name]); err == nil {
+		return v
+	}
+	return def
+}
+
+func (p Properties) Float(name string, def float64) float64 {
+	if v, err := strconv.ParseFloat(p[name], 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func (p Properties) Bool(name string, def bool) bool {
+	if v, err := strconv.ParseBool(p[name]); err == nil {
+		return v
+	}
+	return def
+}
+
+// what the tiles in the Impact collision tileset mean. the rest are empty
+var impactCollision = map[int]TileCollision{
+	1: TC_SOLID,
+	2: TC_ONEWAY,
+}
+
+// load a level, picking the format from the file extension
+func LoadLevel(fname string) (*Level, error) {
+	switch filepath.Ext(fname) {
+	case ".tmx":
+		return loadTmxLevel(fname)
+	case ".json":
+		return loadImpactLevel(fname)
+	}
+	return nil, fmt.Errorf("%s: unknown level format", fname)
+}
+
+func loadTmxLevel(fname string) (*Level, error) {
+	freader, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer freader.Close()
+
+	gmap, err := tmx.Read(freader)
+	if err != nil {
+		return nil, err
+	}
+
+	lvl := &Level{Map: *gmap}
+	lvl.Collision = NewCollisionMap(&lvl.Map, "world")
+
+	for i := range lvl.Map.ObjectGroups {
+		for j := range lvl.Map.ObjectGroups[i].Objects {
+			obj := &lvl.Map.ObjectGroups[i].Objects[j]
+
+			// tile objects hang up from their bottom left corner
+			y := obj.Y
+			if obj.GID != 0 {
+				y -= obj.Height
+			}
+
+			so := SpawnObject{
+				Type:  obj.Type,
+				Name:  obj.Name,
+				Pos:   Vec2{Fx(obj.X), Fx(y)},
+				Size:  Size{int32(obj.Width), int32(obj.Height)},
+				Props: Properties{},
+			}
+			for _, p := range obj.Properties {
+				so.Props[p.Name] = p.Value
+			}
+			if obj.GID != 0 {
+				if tile, err := lvl.Map.DecodeGID(tmx.GID(obj.GID)); err == nil && !tile.IsNil() {
+					so.Tile = tile
+				}
+			}
+			lvl.Objects = append(lvl.Objects, so)
+		}
+	}
+
+	return lvl, nil
+}
+
+func loadImpactLevel(fname string) (*Level, error) {
+	level, err := gamemap.Load(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	lvl := &Level{}
+	m := &lvl.Map
+	collision := level.Collision()
+
+	// one tileset per image. these all have to exist before the layers point
+	// into them
+	tilesets := make(map[string]int)
+	for i := range level.Layers {
+		layer := &level.Layers[i]
+		if layer == collision {
+			continue
+		}
+		if _, ok := tilesets[layer.TilesetName]; ok {
+			continue
+		}
+
+		// tile ids are worked out from how many tiles fit across the image, so
+		// its size is needed up front
+		source := path.Base(layer.TilesetName)
+		w, h, err := imageSize(filepath.Join(filepath.Dir(fname), source))
+		if err != nil {
+			return nil, err
+		}
+
+		tilesets[layer.TilesetName] = len(m.Tilesets)
+		m.Tilesets = append(m.Tilesets, tmx.Tileset{
+			Name:       source,
+			TileWidth:  layer.TileSize,
+			TileHeight: layer.TileSize,
+			Image:      tmx.Image{Source: source, Width: w, Height: h},
+		})
+
+		m.Width = max(m.Width, layer.Width)
+		m.Height = max(m.Height, layer.Height)
+		m.TileWidth, m.TileHeight = layer.TileSize, layer.TileSize
+	}
+
+	for i := range level.Layers {
+		layer := &level.Layers[i]
+		if layer == collision {
+			continue
+		}
+
+		ts := &m.Tilesets[tilesets[layer.TilesetName]]
+		tl := tmx.Layer{
+			Name:         layer.Name,
+			Opacity:      1,
+			Visible:      layer.Visible != 0,
+			Tileset:      ts,
+			DecodedTiles: make([]*tmx.DecodedTile, m.Width*m.Height),
+			Empty:        true,
+		}
+		for row := 0; row < m.Height; row++ {
+			for col := 0; col < m.Width; col++ {
+				tile := tmx.NilTile
+				if v := layer.At(col, row); v > 0 {
+					tile = &tmx.DecodedTile{ID: tmx.ID(v - 1), Tileset: ts}
+					tl.Empty = false
+				}
+				tl.DecodedTiles[row*m.Width+col] = tile
+			}
+		}
+		m.Layers = append(m.Layers, tl)
+	}
+
+	lvl.Collision = &CollisionMap{
+		Width:    m.Width,
+		Height:   m.Height,
+		TileSize: Fx(m.TileWidth),
+		Tiles:    make([]TileCollision, m.Width*m.Height),
+	}
+	if collision != nil {
+		for row := 0; row < m.Height; row++ {
+			for col := 0; col < m.Width; col++ {
+				lvl.Collision.Tiles[row*m.Width+col] = impactCollision[collision.At(col, row)]
+			}
+		}
+	}
+
+	for _, ent := range level.Entities {
+		so := SpawnObject{
+			Type:  ent.Type,
+			Pos:   Vec2{Fx(ent.X), Fx(ent.Y)},
+			Props: impactSettings(ent.Settings),
+		}
+		so.Name = so.Props.String("name", "")
+		lvl.Objects = append(lvl.Objects, so)
+	}
+
+	return lvl, nil
+}
+
+// flatten an entity's editor settings into text like Tiled properties
+func impactSettings(settings map[string]interface{}) Properties {
+	props := Properties{}
+	for name, v := range settings {
+		switch v := v.(type) {
+		case string:
+			props[name] = v
+		case float64:
+			props[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			props[name] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			props[name] = string(b)
+		}
+	}
+	return props
+}
+
+// the size of an image without decoding all of it
+func imageSize(fname string) (int, int, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %s", fname, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
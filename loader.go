@@ -0,0 +1,212 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/sdl_image"
+)
+
+const LOADER_WORKERS = 4
+
+// LoadBatch is the handle a scene gets back from EC_LOADIMAGES. Images and
+// Errors line up with Names and are only safe to read once Done is closed.
+// anything that failed gets the missing texture and an *AssetError
+type LoadBatch struct {
+	Names  []string
+	Images []Image
+	Errors []error
+	loaded int32
+	done   chan struct{}
+}
+
+// how many images in the batch have finished, successfully or not
+func (b *LoadBatch) Progress() (loaded int, total int) {
+	return int(atomic.LoadInt32(&b.loaded)), len(b.Names)
+}
+
+// closed once every image in the batch has finished
+func (b *LoadBatch) Done() <-chan struct{} {
+	return b.done
+}
+
+func (b *LoadBatch) finish(i int, image Image, err error) {
+	b.Images[i] = image
+	b.Errors[i] = err
+	if int(atomic.AddInt32(&b.loaded, 1)) == len(b.Names) {
+		close(b.done)
+	}
+}
+
+type decodedImage struct {
+	fname   string
+	surface *sdl.Surface
+	err     error
+}
+
+type batchEntry struct {
+	batch *LoadBatch
+	index int
+}
+
+// ImageLoader decodes images on worker goroutines and hands the surfaces back
+// to the engine thread, which is the only place textures can be created
+type ImageLoader struct {
+	textures *TextureManager
+	jobs     chan string
+	decoded  chan decodedImage
+	queue    []string
+	waiting  map[string][]batchEntry
+	batches  []*LoadBatch
+	quit     chan struct{}
+	workers  sync.WaitGroup
+}
+
+func NewImageLoader(textures *TextureManager) *ImageLoader {
+	l := &ImageLoader{
+		textures: textures,
+		jobs:     make(chan string, LOADER_WORKERS),
+		decoded:  make(chan decodedImage, 64),
+		waiting:  make(map[string][]batchEntry),
+		quit:     make(chan struct{}),
+	}
+
+	l.workers.Add(LOADER_WORKERS)
+	for i := 0; i < LOADER_WORKERS; i++ {
+		go l.worker()
+	}
+
+	return l
+}
+
+func (l *ImageLoader) worker() {
+	defer l.workers.Done()
+
+	for fname := range l.jobs {
+		surface, err := img.Load(fname)
+		select {
+		case l.decoded <- decodedImage{fname: fname, surface: surface, err: err}:
+		case <-l.quit:
+			if surface != nil {
+				surface.Free()
+			}
+			return
+		}
+	}
+}
+
+// start loading a set of images. anything already resident resolves immediately,
+// and an image requested by several batches at once is only decoded once
+func (l *ImageLoader) Queue(names []string) *LoadBatch {
+	b := &LoadBatch{
+		Names:  names,
+		Images: make([]Image, len(names)),
+		Errors: make([]error, len(names)),
+		done:   make(chan struct{}),
+	}
+
+	if len(names) == 0 {
+		close(b.done)
+		return b
+	}
+
+	for i, fname := range names {
+		if image, ok := l.textures.Ref(fname); ok {
+			b.finish(i, image, nil)
+			continue
+		}
+
+		if _, ok := l.waiting[fname]; !ok {
+			l.queue = append(l.queue, fname)
+		}
+		l.waiting[fname] = append(l.waiting[fname], batchEntry{batch: b, index: i})
+	}
+
+	l.batches = append(l.batches, b)
+	return b
+}
+
+// hand queued images to the workers and upload whatever they have finished
+// decoding. called once per frame on the engine thread
+func (l *ImageLoader) Update() {
+feed:
+	for len(l.queue) > 0 {
+		select {
+		case l.jobs <- l.queue[0]:
+			l.queue = l.queue[1:]
+		default:
+			break feed
+		}
+	}
+
+	for {
+		select {
+		case d := <-l.decoded:
+			l.upload(d)
+		default:
+			l.prune()
+			return
+		}
+	}
+}
+
+// combined progress of every batch that is still loading
+func (l *ImageLoader) Progress() (loaded int, total int) {
+	for _, b := range l.batches {
+		n, t := b.Progress()
+		loaded += n
+		total += t
+	}
+	return loaded, total
+}
+
+// stop the workers and wait for them to exit. anything still in flight is dropped
+func (l *ImageLoader) Close() {
+	close(l.quit)
+	close(l.jobs)
+	l.workers.Wait()
+
+	for {
+		select {
+		case d := <-l.decoded:
+			if d.surface != nil {
+				d.surface.Free()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *ImageLoader) upload(d decodedImage) {
+	waiters := l.waiting[d.fname]
+	delete(l.waiting, d.fname)
+
+	var image Image
+	var err error
+	if d.err != nil {
+		image, err = l.textures.Missing(), &AssetError{Path: d.fname, Err: d.err}
+	} else {
+		image, err = l.textures.Upload(d.fname, d.surface, len(waiters))
+		d.surface.Free()
+	}
+
+	for _, w := range waiters {
+		w.batch.finish(w.index, image, err)
+	}
+}
+
+// forget about batches that have completed
+func (l *ImageLoader) prune() {
+	n := 0
+	for _, b := range l.batches {
+		select {
+		case <-b.done:
+		default:
+			l.batches[n] = b
+			n++
+		}
+	}
+	l.batches = l.batches[:n]
+}
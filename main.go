@@ -1,13 +1,41 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
 	"runtime"
+	"time"
 
 	"github.com/veandco/go-sdl2/sdl"
-	"github.com/veandco/go-sdl2/sdl_image"
 )
 
+const SHUTDOWN_TIMEOUT = 2 * time.Second
+
+// the virtual screen, in world pixels. the window is some multiple of this
+const (
+	VIRTUAL_WIDTH  = 320
+	VIRTUAL_HEIGHT = 180
+)
+
+var noLerp = flag.Bool("nolerp", false, "draw the latest game state without interpolating, for debugging")
+var level = flag.String("level", DEFAULT_LEVEL, "level to play from the base folder, a Tiled .tmx or an Impact .json")
+var winWidth = flag.Int("width", 1280, "window width")
+var winHeight = flag.Int("height", 720, "window height")
+var virtWidth = flag.Int("vwidth", VIRTUAL_WIDTH, "width of the virtual screen the game draws to")
+var virtHeight = flag.Int("vheight", VIRTUAL_HEIGHT, "height of the virtual screen the game draws to")
+var record = flag.String("record", "", "record every step's input to this file")
+var play = flag.String("play", "", "play back a recording, then carry on with live input")
+var verify = flag.String("verify", "", "play back a recording without a window, check it comes out the same, and quit")
+var serve = flag.String("serve", "", "run a server for the level on this address without a window, like :7777")
+var connect = flag.String("connect", "", "play on the server at this address")
+var netLag = flag.Duration("netlag", 0, "hold back packets by this much, to test playing over a bad network")
+var netLoss = flag.Float64("netloss", 0, "fraction of packets to lose, to test playing over a bad network")
+var scaling = flag.String("scaling", "integer", "how the virtual screen fills the window, integer or letterbox")
+
 func init() {
 	runtime.LockOSThread()
 	//debug.SetGCPercent(-1)
@@ -15,138 +43,188 @@ func init() {
 
 var engCmd EngineCommand
 var event sdl.Event
-var rcmds *RenderCommandList
 var rc *RenderCommand
 var srcRect sdl.Rect
 var dstRect sdl.Rect
 
 func main() {
+	flag.Parse()
 	fmt.Println("Starting up...")
 
-	sdl.Init(sdl.INIT_EVERYTHING)
+	scale, ok := scalingNames[*scaling]
+	if !ok {
+		fmt.Printf("Unknown scaling %q\n", *scaling)
+		return
+	}
+	if *virtWidth <= 0 || *virtHeight <= 0 {
+		fmt.Println("The virtual screen needs a size")
+		return
+	}
+
+	if *verify != "" {
+		ticks, err := VerifyReplay(*verify)
+		if err != nil {
+			fmt.Printf("%s: %s\n", *verify, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d steps ok\n", *verify, ticks)
+		return
+	}
+
+	if *serve != "" {
+		if err := runServer(*serve); err != nil {
+			fmt.Printf("Server: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	winWidth := 1280
-	winHeight := 720
+	sdl.Init(sdl.INIT_EVERYTHING)
 
 	// create window context
-	window, err := sdl.CreateWindow("test", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, winWidth, winHeight, sdl.WINDOW_SHOWN)
+	window, err := sdl.CreateWindow("test", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, *winWidth, *winHeight, sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
 	if err != nil {
 		panic(err)
 	}
 	defer window.Destroy()
 
-	// create renderer context
-	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED|sdl.RENDERER_PRESENTVSYNC) // |sdl.RENDERER_PRESENTVSYNC
+	// create renderer context. scene transitions need to render to textures
+	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED|sdl.RENDERER_PRESENTVSYNC|sdl.RENDERER_TARGETTEXTURE)
 	if err != nil {
 		panic(err)
 	}
 	defer renderer.Destroy()
 
-	// we're done loading the game, start the update loop
-	sceneCh := SceneChannels{RCmd: make(chan *RenderCommandList, 1), Ev: make(chan Event, 256), Eng: make(chan EngineCommand), Err: make(chan error)}
-
-	// load the gamescene and have it immediately start pumping out gamestates in a thread
-	gameScene := GameScene{}
-	//gameScene.Camera.SetSize(Size{int32(winWidth), int32(winHeight)})
-	go gameScene.Load(sceneCh)
-
-	textures := make([]*sdl.Texture, 1024, 1024)
-	curTex := 1
-
-	for {
-		// process engine commands from the scene
-		// scenes should block on waiting for the engine to return
-		// if you want an engine function that doesn't block, just send a
-		// response back on the channel immediately to ensure that all
-		// calls from the scene can take the same procedure
-		select {
-		case engCmd = <-sceneCh.Eng:
-			switch engCmd.Id {
-			// load an image from disk and upload to gpu
-			case EC_LOADIMAGE:
-				fname := engCmd.Data.(string)
-				image, err := img.Load(fname)
-				if err != nil {
-					fmt.Printf("Failed to load PNG: %s\n", err)
-					return
-				}
-				defer image.Free()
-
-				texture, err := renderer.CreateTextureFromSurface(image)
-				if err != nil {
-					panic("Error in CreateTextureFromSurface")
-				}
+	engine, err := NewEngine(renderer, int32(*winWidth), int32(*winHeight), Size{int32(*virtWidth), int32(*virtHeight)}, scale)
+	if err != nil {
+		panic(err)
+	}
+	defer engine.Destroy()
 
-				// FIXME: defer? need to delete these somewhere
-				textures[curTex] = texture
+	// we're done loading the game, start the first scene. it immediately starts
+	// pumping out gamestates in its own thread
+	engine.scenes.Push(&GameScene{NoLerp: *noLerp, Level: *level, Record: *record, Play: *play,
+		Connect: *connect, NetLag: *netLag, NetLoss: *netLoss}, TR_FADE)
 
-				_, _, w, h, _ := texture.Query()
-				sceneCh.Eng <- EngineCommand{Id: engCmd.Id, Success: true, Data: Image{Id: curTex, W: w, H: h}}
-				curTex++
-			default:
-				sceneCh.Eng <- EngineCommand{Success: false}
-			}
-		default:
+	for !engine.scenes.Empty() {
+		if err = engine.serviceScenes(); err != nil {
+			fmt.Printf("Scene failed: %s\n", err)
+			break
+		}
 
+		if !pollEvents(engine) {
+			break
 		}
 
-		// poll for input events and push them to the gamestate queue
-		// this can technically fill the queue and block but it is very unlikely
-		// FIXME: SDL_GetKeyboardState?
-		for event = sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch t := event.(type) {
-			case *sdl.QuitEvent:
-				return
-			case *sdl.MouseMotionEvent:
-				sceneCh.Ev <- Event{Type: EV_MOUSEMOVE, Position: Vector{t.X, t.Y}}
+		engine.draw(time.Now())
+	}
+
+	// give the scenes a chance to save and clean up before tearing everything down
+	fmt.Println("Shutting down...")
+	if err = engine.Shutdown(SHUTDOWN_TIMEOUT); err != nil {
+		fmt.Printf("Shutdown: %s\n", err)
+	}
+}
+
+// run a headless server until interrupted
+func runServer(addr string) error {
+	var conn net.PacketConn
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	if *netLag > 0 || *netLoss > 0 {
+		conn = NewLossyConn(conn, *netLag, *netLag/4, *netLoss)
+	}
 
-			case *sdl.MouseButtonEvent:
-				sceneCh.Ev <- Event{Type: EV_MOUSECLICK, Down: t.State != 0, EvData1: int(t.Button)}
+	server, err := NewServer(conn, *level, 0)
+	if err != nil {
+		conn.Close()
+		return err
+	}
 
-			case *sdl.MouseWheelEvent:
-				sceneCh.Ev <- Event{Type: EV_MOUSEWHEEL, Position: Vector{t.X, t.Y}}
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
 
-			case *sdl.KeyDownEvent:
-				sceneCh.Ev <- Event{Type: EV_KEY, Down: true, EvData1: int(t.Keysym.Scancode)}
+	fmt.Printf("Serving %s on %s\n", *level, conn.LocalAddr())
+	return server.Serve(ctx)
+}
 
-			case *sdl.KeyUpEvent:
-				sceneCh.Ev <- Event{Type: EV_KEY, Down: false, EvData1: int(t.Keysym.Scancode)}
+// poll for input events and push them to the top scene's queue. the queue
+// never blocks, so a scene that has stalled just misses input. mouse
+// positions are in virtual screen pixels, the renderer takes care of that.
+// returns false once the window has been closed
+// FIXME: SDL_GetKeyboardState?
+func pollEvents(engine *Engine) bool {
+	for event = sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch t := event.(type) {
+		case *sdl.QuitEvent:
+			return false
+
+		// the engine tells every scene, not just the top one
+		case *sdl.WindowEvent:
+			if t.Event == sdl.WINDOWEVENT_RESIZED {
+				if err := engine.Resize(t.Data1, t.Data2); err != nil {
+					fmt.Printf("Resize: %s\n", err)
+				}
 			}
-		}
+			continue
 
-		select {
-		case err = <-sceneCh.Err: // we have an error from the gamestate
-			panic(err)
-			return
-		default:
+		// added events say which device it is, everything after that says
+		// which controller. every scene hears about these so none of them are
+		// left holding buttons on a pad that's gone
+		case *sdl.ControllerDeviceEvent:
+			switch t.Type {
+			case sdl.CONTROLLERDEVICEADDED:
+				if slot := engine.pads.Add(int(t.Which)); slot >= 0 {
+					engine.scenes.Broadcast(Event{Type: EV_PADADDED, Pad: slot})
+				}
+			case sdl.CONTROLLERDEVICEREMOVED:
+				if slot := engine.pads.Remove(t.Which); slot >= 0 {
+					engine.scenes.Broadcast(Event{Type: EV_PADREMOVED, Pad: slot})
+				}
+			}
+			continue
 		}
 
-		if !gameScene.ready {
+		top := engine.scenes.Top()
+		if top == nil {
 			continue
 		}
 
-		rcmds = gameScene.render()
-		renderer.SetDrawColor(0, 0, 0, 255)
-		renderer.Clear()
+		switch t := event.(type) {
+		case *sdl.MouseMotionEvent:
+			top.ch.Ev.Push(Event{Type: EV_MOUSEMOVE, Position: Vector{t.X, t.Y}})
 
-		for i := 0; i < int(rcmds.NumCommands); i++ {
-			rc = &rcmds.Commands[i]
+		case *sdl.MouseButtonEvent:
+			top.ch.Ev.Push(Event{Type: EV_MOUSECLICK, Down: t.State != 0, EvData1: int(t.Button), Position: Vector{t.X, t.Y}})
 
-			switch rc.Id {
-			case RC_PIC:
-				if rc.ImgSize.W > 0 && rc.ImgSize.H > 0 {
-					srcRect = sdl.Rect{rc.ImgPos.X, rc.ImgPos.Y, rc.ImgSize.W, rc.ImgSize.H}
-				}
-				dstRect = sdl.Rect{rc.Pos.X, rc.Pos.Y, rc.Size.W, rc.Size.H}
-				renderer.Copy(textures[rc.ImageId], &srcRect, &dstRect)
-			case RC_RECT:
-				renderer.SetDrawColor(rc.BackColor.R, rc.BackColor.G, rc.BackColor.B, rc.BackColor.A)
-				dstRect = sdl.Rect{rc.Pos.X, rc.Pos.Y, rc.Size.W, rc.Size.H}
-				renderer.FillRect(&dstRect)
-				renderer.SetDrawColor(0, 0, 0, 255)
+		case *sdl.MouseWheelEvent:
+			top.ch.Ev.Push(Event{Type: EV_MOUSEWHEEL, Position: Vector{t.X, t.Y}})
+
+		case *sdl.KeyDownEvent:
+			top.ch.Ev.Push(Event{Type: EV_KEY, Down: true, EvData1: int(t.Keysym.Scancode)})
+
+		case *sdl.KeyUpEvent:
+			top.ch.Ev.Push(Event{Type: EV_KEY, Down: false, EvData1: int(t.Keysym.Scancode)})
+
+		// scenes only see gamepad slots, not which controller it was
+		case *sdl.ControllerButtonEvent:
+			if slot := engine.pads.Slot(t.Which); slot >= 0 {
+				top.ch.Ev.Push(Event{Type: EV_PADBUTTON, Down: t.State != 0, EvData1: int(t.Button), Pad: slot})
 			}
-		}
 
-		renderer.Present()
+		case *sdl.ControllerAxisEvent:
+			if slot := engine.pads.Slot(t.Which); slot >= 0 {
+				top.ch.Ev.Push(Event{Type: EV_PADAXIS, EvData1: int(t.Axis), EvData2: int(t.Value), Pad: slot})
+			}
+		}
 	}
+
+	return true
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// the game over UDP. the server runs the simulation and clients send it their
+// commands, numbered so the server can say which one it got up to. the
+// server sends back snapshots of the state, each one only holding what
+// changed since a snapshot the client said it had, so losing a packet never
+// needs anything resent. clients run their own player ahead of the server so
+// it moves as soon as they press something, and replay whatever the server
+// hasn't seen yet on top of each snapshot that comes in
+const (
+	NET_VERSION      = 1
+	MAX_PACKET       = 65000 // what fits in a UDP datagram, with a little to spare
+	SNAPSHOT_HISTORY = 64    // states kept to send deltas from, a power of two
+	SNAPSHOT_EVERY   = 2     // steps between snapshots
+	MAX_PENDING      = 64    // commands a client remembers before the server has seen them
+	RESEND_COMMANDS  = 8     // commands in each packet, so a lost one comes again in the next
+	MAX_QUEUED       = 4     // commands a server lets pile up before skipping ahead
+	MAX_CLIENTS      = 8     // players on a server at once
+	CONNECT_RETRY    = 250 * time.Millisecond
+	NET_TIMEOUT      = 5 * time.Second // silence before giving up on the other end
+)
+
+// packet types, the first byte of every packet
+const (
+	PK_CONNECT    byte = 1 + iota // client: version uint16
+	PK_WELCOME                    // server: tick rate uint16, level as a uvarint length and bytes
+	PK_COMMANDS                   // client: last snapshot tick uint64, count byte, then count netCommands oldest first
+	PK_SNAPSHOT                   // server: see encodeSnapshot
+	PK_DISCONNECT                 // either
+)
+
+var (
+	ErrBadPacket    = errors.New("bad packet")
+	ErrNetRefused   = errors.New("server refused the connection")
+	ErrNetTimeout   = errors.New("connection timed out")
+	ErrSnapshotSize = errors.New("snapshot too big for a packet")
+)
+
+// a command and where it comes in the client's sequence
+type netCommand struct {
+	Seq     uint32
+	Up      uint8
+	Down    uint8
+	Left    uint8
+	Right   uint8
+	Buttons uint32
+	MouseX  int32
+	MouseY  int32
+}
+
+func toNetCommand(seq uint32, cmd UserCommand) netCommand {
+	return netCommand{
+		Seq: seq, Up: axisByte(cmd.Up), Down: axisByte(cmd.Down), Left: axisByte(cmd.Left), Right: axisByte(cmd.Right),
+		Buttons: cmd.Buttons, MouseX: cmd.Mouse.X, MouseY: cmd.Mouse.Y,
+	}
+}
+
+func (nc netCommand) UserCommand() UserCommand {
+	return UserCommand{
+		Up: int(nc.Up), Down: int(nc.Down), Left: int(nc.Left), Right: int(nc.Right),
+		Buttons: nc.Buttons, Mouse: Vector{nc.MouseX, nc.MouseY},
+	}
+}
+
+// a snapshot is this and then an EncodeDelta from the state on Base to the
+// state on Tick, or from an empty GameState when Base is 0
+type snapshotHeader struct {
+	Tick     uint64
+	Base     uint64
+	Ack      uint32   // the last of this client's commands that has been run
+	LocalEnt EntityId // this client's player
+}
+
+var emptyState GameState
+
+func encodeSnapshot(st, base *GameState, hdr snapshotHeader) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(PK_SNAPSHOT)
+
+	hdr.Tick, hdr.Base = st.Tick, 0
+	if base != nil {
+		hdr.Base = base.Tick
+	} else {
+		base = &emptyState
+	}
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(EncodeDelta(base, st))
+
+	if buf.Len() > MAX_PACKET {
+		return nil, ErrSnapshotSize
+	}
+	return buf.Bytes(), nil
+}
+
+// read the header of a snapshot packet, past the type byte
+func readSnapshotHeader(r io.Reader) (snapshotHeader, error) {
+	var hdr snapshotHeader
+	err := binary.Read(r, binary.LittleEndian, &hdr)
+	return hdr, err
+}
+
+// states by tick, for deltas. only the last SNAPSHOT_HISTORY are kept
+type snapshotRing struct {
+	states [SNAPSHOT_HISTORY]GameState
+	ticks  [SNAPSHOT_HISTORY]uint64
+}
+
+func (r *snapshotRing) Put(st *GameState) {
+	i := st.Tick % SNAPSHOT_HISTORY
+	r.states[i] = *st
+	r.ticks[i] = st.Tick
+}
+
+// the state on a tick, or nil if it's too old or was never kept
+func (r *snapshotRing) Get(tick uint64) *GameState {
+	i := tick % SNAPSHOT_HISTORY
+	if tick == 0 || r.ticks[i] != tick {
+		return nil
+	}
+	return &r.states[i]
+}
+
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// read packets off a connection into a channel until it's closed, so they can
+// be picked up between steps without blocking
+func readPackets(conn net.PacketConn) <-chan packet {
+	ch := make(chan packet, 256)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, MAX_PACKET+1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				return
+			}
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			select {
+			case ch <- packet{data, addr}:
+			default: // nobody's keeping up, which is the same as it getting lost
+			}
+		}
+	}()
+	return ch
+}
+
+// LossyConn makes a connection behave like a bad network, for testing over
+// loopback. packets going out are held back by Latency give or take Jitter,
+// which can put them out of order, and a Loss fraction of them never arrive
+type LossyConn struct {
+	net.PacketConn
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewLossyConn(conn net.PacketConn, latency, jitter time.Duration, loss float64) *LossyConn {
+	return &LossyConn{
+		PacketConn: conn,
+		Latency:    latency,
+		Jitter:     jitter,
+		Loss:       loss,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (c *LossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	lost := c.rand.Float64() < c.Loss
+	delay := c.Latency
+	if c.Jitter > 0 {
+		delay += time.Duration(c.rand.Int63n(int64(2*c.Jitter))) - c.Jitter
+	}
+	c.mu.Unlock()
+
+	if lost {
+		return len(p), nil
+	}
+	if delay <= 0 {
+		return c.PacketConn.WriteTo(p, addr)
+	}
+
+	data := make([]byte, len(p))
+	copy(data, p)
+	time.AfterFunc(delay, func() {
+		c.PacketConn.WriteTo(data, addr)
+	})
+	return len(p), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a connection on a free loopback port that loses and holds back packets
+func lossyLoopback(t *testing.T, lag time.Duration, loss float64) *LossyConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLossyConn(conn, lag, lag/4, loss)
+}
+
+// a client playing on a server over a bad connection ends up with its player
+// where the server has it once it stops moving, and the server moves it
+// where the client said to go
+func TestClientConverges(t *testing.T) {
+	const (
+		lag  = 30 * time.Millisecond
+		loss = 0.2
+	)
+
+	srv, err := NewServer(lossyLoopback(t, lag, loss), DEFAULT_LEVEL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	step := srv.scene.step
+
+	// the server has to be stepping for the client to hear back when it
+	// connects, then both are stepped from here so nothing races
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(step):
+				srv.Step(time.Now())
+			}
+		}
+	}()
+	conn := lossyLoopback(t, lag, loss)
+	c, err := Dial(conn, srv.conn.LocalAddr())
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	level, err := LoadLevel("base/" + c.Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GameScene{Level: c.Level, TickRate: c.TickRate}
+	if s.sprites, err = LoadSprites("base/" + SPRITES_FILE); err != nil {
+		t.Fatal(err)
+	}
+	s.setup(level)
+	s.client = c
+
+	run := func(cmd UserCommand, steps int) {
+		for i := 0; i < steps; i++ {
+			if err := srv.Step(time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Step(s, cmd); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(step)
+		}
+	}
+
+	// wait for the first snapshot, then walk right for a second and stand
+	// still for long enough for everything to arrive
+	run(UserCommand{}, c.TickRate/2)
+	start := srv.scene.state.Entities.Get(c.ent)
+	if start == nil {
+		t.Fatal("no player on the server")
+	}
+	startX := start.Pos.X
+	run(UserCommand{Right: 255}, c.TickRate)
+	run(UserCommand{}, c.TickRate)
+
+	want := srv.scene.state.Entities.Get(c.ent)
+	got := s.state.Entities.Get(s.state.LocalEnt)
+	if got == nil {
+		t.Fatal("no player on the client")
+	}
+	if want.Pos.X <= startX {
+		t.Errorf("player on the server didn't move right from %v", startX.Float())
+	}
+	if got.Pos != want.Pos {
+		t.Errorf("client has the player at %v,%v, server has %v,%v",
+			got.Pos.X.Float(), got.Pos.Y.Float(), want.Pos.X.Float(), want.Pos.Y.Float())
+	}
+}
+
+func TestServerFull(t *testing.T) {
+	srv, err := NewServer(lossyLoopback(t, 0, 0), DEFAULT_LEVEL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	for i := 0; i < MAX_CLIENTS; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000 + i}
+		c := srv.join(addr)
+		if c == nil {
+			t.Fatalf("client %d couldn't join", i)
+		}
+		srv.clients[addr.String()] = c
+	}
+	if srv.join(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 999}) != nil {
+		t.Error("joined a full server")
+	}
+}
+
+// a snapshot that would have the client indexing off the end of something is
+// dropped, and a good one after it still gets through
+func TestClientDropsBadSnapshot(t *testing.T) {
+	s := newTestScene(t, testObject("player_start", 16, 32))
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	packets := make(chan packet, 3)
+	c := &Client{server: addr, packets: packets}
+
+	send := func(tick uint64, local EntityId, change func(*GameState)) {
+		st := s.state
+		st.Tick = tick
+		change(&st)
+		data, err := encodeSnapshot(&st, nil, snapshotHeader{LocalEnt: local})
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets <- packet{data, addr}
+	}
+	player := s.state.LocalEnt
+	send(1, player, func(st *GameState) { st.Entities.Ents[player.slot()].Type = NUM_ENTITY_TYPES })
+	send(2, player, func(st *GameState) { st.Entities.NumLive = MAX_ENTITIES + 1 })
+	send(3, 0xffff, func(st *GameState) {})
+
+	if fresh, err := c.receive(s.sprites); err != nil || fresh {
+		t.Fatalf("took a bad snapshot, up to tick %d", c.latest)
+	}
+
+	send(4, player, func(st *GameState) {})
+	if fresh, err := c.receive(s.sprites); err != nil || !fresh || c.latest != 4 {
+		t.Errorf("didn't take a good snapshot after the bad ones, up to tick %d", c.latest)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// Client is the connection to a server from a GameScene. the scene's state
+// is the newest snapshot from the server with the player's own commands
+// that the server hasn't run yet played on top of it
+type Client struct {
+	Level    string // what the server is playing
+	TickRate int
+	conn     net.PacketConn
+	server   net.Addr
+	packets  <-chan packet
+	seq      uint32
+	pending  []netCommand // sent and not run by the server yet, oldest first
+	snaps    snapshotRing // from the server, for it to send deltas from
+	latest   uint64       // newest snapshot
+	ent      EntityId     // the player, which the server's state doesn't know is ours
+	heard    time.Time
+}
+
+// connect to a server over UDP from any free port. lag and loss make the
+// connection worse on purpose, see LossyConn
+func DialUDP(addr string, lag time.Duration, loss float64) (*Client, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	if lag > 0 || loss > 0 {
+		conn = NewLossyConn(conn, lag, lag/4, loss)
+	}
+
+	c, err := Dial(conn, server)
+	if err != nil {
+		conn.Close()
+	}
+	return c, err
+}
+
+// connect to a server, asking until it answers or NET_TIMEOUT goes by
+func Dial(conn net.PacketConn, server net.Addr) (*Client, error) {
+	c := &Client{conn: conn, server: server, packets: readPackets(conn)}
+
+	var hello bytes.Buffer
+	hello.WriteByte(PK_CONNECT)
+	binary.Write(&hello, binary.LittleEndian, uint16(NET_VERSION))
+
+	retry := time.NewTicker(CONNECT_RETRY)
+	defer retry.Stop()
+	timeout := time.After(NET_TIMEOUT)
+	for {
+		conn.WriteTo(hello.Bytes(), server)
+
+		select {
+		case p, ok := <-c.packets:
+			if !ok {
+				return nil, io.ErrClosedPipe
+			}
+			if len(p.data) == 0 || p.addr.String() != server.String() {
+				continue
+			}
+			switch p.data[0] {
+			case PK_WELCOME:
+				if err := c.readWelcome(p.data[1:]); err != nil {
+					return nil, err
+				}
+				c.heard = time.Now()
+				return c, nil
+			case PK_DISCONNECT:
+				return nil, ErrNetRefused
+			}
+		case <-retry.C:
+		case <-timeout:
+			return nil, ErrNetTimeout
+		}
+	}
+}
+
+func (c *Client) readWelcome(data []byte) error {
+	r := bytes.NewReader(data)
+	var rate uint16
+	if err := binary.Read(r, binary.LittleEndian, &rate); err != nil {
+		return ErrBadPacket
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return ErrBadPacket
+	}
+	level := make([]byte, n)
+	io.ReadFull(r, level)
+
+	c.Level, c.TickRate = string(level), int(rate)
+	return nil
+}
+
+// run one step of s with the player's command. it goes to the server, and
+// the player moves straight away without waiting to hear back. when a newer
+// snapshot has come in everything else jumps to where the server says it is,
+// and the player is put back where the server had it and moved on again by
+// everything it's done since
+func (c *Client) Step(s *GameScene, cmd UserCommand) error {
+	c.seq++
+	c.pending = append(c.pending, toNetCommand(c.seq, cmd))
+	if len(c.pending) > MAX_PENDING {
+		c.pending = c.pending[len(c.pending)-MAX_PENDING:]
+	}
+
+	fresh, err := c.receive(s.sprites)
+	if err != nil {
+		return err
+	}
+	if time.Since(c.heard) > NET_TIMEOUT {
+		return ErrNetTimeout
+	}
+	c.sendCommands()
+
+	if !fresh {
+		s.predict(cmd)
+		return nil
+	}
+
+	// the camera is the client's own, only the shake comes from the server
+	prev := s.state
+	cam := s.state.Camera
+	s.state = *c.snaps.Get(c.latest)
+	s.state.LocalEnt = c.ent
+	cam.Trauma = s.state.Camera.Trauma
+	s.state.Camera = cam
+
+	// only the rumbles from the newest step are new, the rest already happened
+	rumbles := len(s.world.Rumbles)
+	for i, nc := range c.pending {
+		if i == len(c.pending)-1 {
+			s.world.Rumbles = s.world.Rumbles[:rumbles]
+		}
+		s.predict(nc.UserCommand())
+	}
+	s.prevState = prev
+	return nil
+}
+
+// read everything that came in, returning true if there was a newer snapshot.
+// snapshots that don't make sense against our sprites are dropped
+func (c *Client) receive(sprites *SpriteSet) (bool, error) {
+	fresh := false
+	for {
+		var p packet
+		select {
+		case p = <-c.packets:
+		default:
+			return fresh, nil
+		}
+		if len(p.data) == 0 || p.addr.String() != c.server.String() {
+			continue
+		}
+
+		switch p.data[0] {
+		case PK_SNAPSHOT:
+			r := bytes.NewReader(p.data[1:])
+			hdr, err := readSnapshotHeader(r)
+			if err != nil || hdr.Tick <= c.latest {
+				continue
+			}
+			delta := p.data[len(p.data)-r.Len():]
+
+			// a delta from a snapshot that has already gone can't be used,
+			// the next one will be from something newer
+			var st GameState
+			if hdr.Base != 0 {
+				base := c.snaps.Get(hdr.Base)
+				if base == nil {
+					continue
+				}
+				st = *base
+			}
+			if ApplyDelta(&st, delta) != nil || st.Tick != hdr.Tick ||
+				st.Validate(sprites) != nil || hdr.LocalEnt.slot() >= MAX_ENTITIES {
+				continue
+			}
+
+			c.snaps.Put(&st)
+			c.latest, c.ent = hdr.Tick, hdr.LocalEnt
+			c.heard = time.Now()
+			fresh = true
+
+			n := 0
+			for n < len(c.pending) && c.pending[n].Seq <= hdr.Ack {
+				n++
+			}
+			c.pending = c.pending[n:]
+
+		case PK_DISCONNECT:
+			return false, io.EOF
+		}
+	}
+}
+
+// send the newest few commands, so one that's lost comes again with the next
+func (c *Client) sendCommands() {
+	cmds := c.pending
+	if len(cmds) > RESEND_COMMANDS {
+		cmds = cmds[len(cmds)-RESEND_COMMANDS:]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(PK_COMMANDS)
+	binary.Write(&buf, binary.LittleEndian, c.latest)
+	buf.WriteByte(byte(len(cmds)))
+	binary.Write(&buf, binary.LittleEndian, cmds)
+	c.conn.WriteTo(buf.Bytes(), c.server)
+}
+
+func (c *Client) Close() error {
+	c.conn.WriteTo([]byte{PK_DISCONNECT}, c.server)
+	return c.conn.Close()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Server runs the one true copy of the game for everyone connected to it.
+// it's a GameScene without an engine, stepped by the server itself with
+// each player following the commands from their own client
+type Server struct {
+	conn    net.PacketConn
+	packets <-chan packet
+	scene   *GameScene
+	start   *SpawnObject // where players after the first come in
+	spare   EntityId     // the level's own player, until a client takes it
+	clients map[string]*remoteClient
+	history snapshotRing
+}
+
+// a client as the server sees it
+type remoteClient struct {
+	addr  net.Addr
+	ent   EntityId
+	queue []netCommand // received and waiting for their step
+	seq   uint32       // newest command received
+	ack   uint32       // newest command run
+	cmd   UserCommand  // what the player is doing, kept when the client falls behind
+	base  uint64       // newest snapshot the client has
+	heard time.Time
+	big   bool // the last snapshot for it didn't fit in a packet
+}
+
+// start a server for a level from the base folder on conn
+func NewServer(conn net.PacketConn, levelName string, tickRate int) (*Server, error) {
+	level, err := LoadLevel("base/" + levelName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		conn:    conn,
+		scene:   &GameScene{Level: levelName, TickRate: tickRate},
+		clients: make(map[string]*remoteClient),
+	}
+	s.scene.sprites, err = LoadSprites("base/" + SPRITES_FILE)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range s.scene.setup(level) {
+		fmt.Printf("%s: %s\n", levelName, err)
+	}
+
+	for i := range level.Objects {
+		if typ := level.Objects[i].Type; typ == "player_start" || typ == "EntityPlayer" {
+			s.start = &level.Objects[i]
+			break
+		}
+	}
+	if s.start == nil {
+		return nil, fmt.Errorf("%s: nowhere for players to start", levelName)
+	}
+
+	s.spare = s.scene.state.LocalEnt
+	s.scene.world.Cmds = make(map[EntityId]UserCommand)
+	s.packets = readPackets(conn)
+	return s, nil
+}
+
+// step the game at its tick rate until ctx is cancelled
+func (s *Server) Serve(ctx context.Context) error {
+	loop := time.NewTicker(s.scene.step)
+	defer loop.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return nil
+		case now := <-loop.C:
+			if err := s.Step(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// take in whatever the clients have sent, run one step with their commands
+// and send snapshots out if it's time
+func (s *Server) Step(now time.Time) error {
+	if err := s.receive(now); err != nil {
+		return err
+	}
+
+	for key, c := range s.clients {
+		if now.Sub(c.heard) > NET_TIMEOUT {
+			fmt.Printf("Client %s timed out\n", c.addr)
+			s.drop(key)
+		}
+	}
+
+	// one command per step each. a client that got ahead, usually after the
+	// network stalled, skips to its newest few so it doesn't stay behind
+	cmds := s.scene.world.Cmds
+	for id := range cmds {
+		delete(cmds, id)
+	}
+	for _, c := range s.clients {
+		if len(c.queue) > MAX_QUEUED {
+			c.queue = c.queue[len(c.queue)-MAX_QUEUED:]
+		}
+		if len(c.queue) > 0 {
+			c.cmd = c.queue[0].UserCommand()
+			c.ack = c.queue[0].Seq
+			c.queue = c.queue[1:]
+		}
+		cmds[c.ent] = c.cmd
+	}
+
+	s.scene.update(UserCommand{})
+	s.scene.world.Rumbles = s.scene.world.Rumbles[:0]
+
+	st := &s.scene.state
+	if st.Tick%SNAPSHOT_EVERY != 0 {
+		return nil
+	}
+	s.history.Put(st)
+	for _, c := range s.clients {
+		s.sendSnapshot(c, st)
+	}
+	return nil
+}
+
+// send a client the state as a delta from the newest one it has. when that
+// doesn't fit in a packet the client goes without this time, and catches up
+// once it does fit again
+func (s *Server) sendSnapshot(c *remoteClient, st *GameState) {
+	hdr := snapshotHeader{Ack: c.ack, LocalEnt: c.ent}
+	data, err := encodeSnapshot(st, s.history.Get(c.base), hdr)
+	if err != nil {
+		if !c.big {
+			fmt.Printf("Client %s: skipping snapshots on tick %d: %s\n", c.addr, st.Tick, err)
+		}
+		c.big = true
+		return
+	}
+	c.big = false
+	s.conn.WriteTo(data, c.addr)
+}
+
+func (s *Server) receive(now time.Time) error {
+	for {
+		var p packet
+		select {
+		case p = <-s.packets:
+		default:
+			return nil
+		}
+		if len(p.data) == 0 {
+			continue
+		}
+
+		key := p.addr.String()
+		c := s.clients[key]
+		r := bytes.NewReader(p.data[1:])
+		switch p.data[0] {
+		case PK_CONNECT:
+			var version uint16
+			if binary.Read(r, binary.LittleEndian, &version) != nil || version != NET_VERSION {
+				s.conn.WriteTo([]byte{PK_DISCONNECT}, p.addr)
+				continue
+			}
+			if c == nil {
+				c = s.join(p.addr)
+				if c == nil {
+					s.conn.WriteTo([]byte{PK_DISCONNECT}, p.addr)
+					continue
+				}
+				s.clients[key] = c
+			}
+			c.heard = now
+			s.welcome(c)
+
+		case PK_COMMANDS:
+			if c == nil {
+				continue
+			}
+			var head struct {
+				Base  uint64
+				Count uint8
+			}
+			if binary.Read(r, binary.LittleEndian, &head) != nil {
+				continue
+			}
+			cmds := make([]netCommand, head.Count)
+			if binary.Read(r, binary.LittleEndian, cmds) != nil {
+				continue
+			}
+
+			c.heard = now
+			if head.Base > c.base {
+				c.base = head.Base
+			}
+			for _, nc := range cmds {
+				if nc.Seq > c.seq {
+					c.queue = append(c.queue, nc)
+					c.seq = nc.Seq
+				}
+			}
+
+		case PK_DISCONNECT:
+			if c != nil {
+				s.drop(key)
+			}
+		}
+	}
+}
+
+// give a new client a player, or nil if there's no room for another. the
+// first one gets the level's own
+func (s *Server) join(addr net.Addr) *remoteClient {
+	if len(s.clients) >= MAX_CLIENTS {
+		fmt.Printf("Client %s: server is full\n", addr)
+		return nil
+	}
+
+	st := &s.scene.state
+	ent := st.Entities.Get(s.spare)
+	s.spare = NO_ENTITY
+	if ent == nil {
+		ent = spawnFuncs[s.start.Type](&s.scene.world, s.start)
+		if ent == nil {
+			fmt.Printf("Client %s: no room for another player\n", addr)
+			return nil
+		}
+	}
+
+	fmt.Printf("Client %s joined\n", addr)
+	return &remoteClient{addr: addr, ent: ent.Id}
+}
+
+// the client keeps asking to connect until this gets there
+func (s *Server) welcome(c *remoteClient) {
+	var buf bytes.Buffer
+	buf.WriteByte(PK_WELCOME)
+	binary.Write(&buf, binary.LittleEndian, uint16(s.scene.TickRate))
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(s.scene.Level)))])
+	buf.WriteString(s.scene.Level)
+	s.conn.WriteTo(buf.Bytes(), c.addr)
+}
+
+func (s *Server) drop(key string) {
+	c := s.clients[key]
+	s.scene.state.Entities.Despawn(c.ent)
+	delete(s.clients, key)
+	fmt.Printf("Client %s left\n", c.addr)
+}
+
+// tell everyone the server is going away
+func (s *Server) Close() error {
+	for key, c := range s.clients {
+		s.conn.WriteTo([]byte{PK_DISCONNECT}, c.addr)
+		delete(s.clients, key)
+	}
+	return s.conn.Close()
+}
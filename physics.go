@@ -0,0 +1,301 @@
+package main
+
+import "./tmx"
+
+type TileCollision uint8
+
+const (
+	TC_EMPTY TileCollision = iota
+	TC_SOLID
+	TC_ONEWAY     // only solid when landed on from above
+	TC_SLOPE_UP   // floor rises from the bottom left corner to the top right
+	TC_SLOPE_DOWN // floor falls from the top left corner to the bottom right
+)
+
+// what an entity ran into during its last move
+type Contacts uint8
+
+const (
+	CT_GROUND Contacts = 1 << iota
+	CT_CEILING
+	CT_WALL_LEFT
+	CT_WALL_RIGHT
+
+	CT_WALL = CT_WALL_LEFT | CT_WALL_RIGHT
+)
+
+// Body is the part of an entity the physics cares about
+type Body struct {
+	Collide     bool  // stop at solid tiles. without this the entity flies through everything
+	Gravity     Fixed // added to Vel.Y every step
+	MaxFall     Fixed // fastest Vel.Y can get from gravity. zero means no limit
+	DropThrough bool  // fall through one way platforms this step
+	Contacts    Contacts
+}
+
+func (b *Body) OnGround() bool {
+	return b.Contacts&CT_GROUND != 0
+}
+
+// CollisionMap is the solid parts of a level, one entry per tile
+type CollisionMap struct {
+	Width    int
+	Height   int
+	TileSize Fixed
+	Tiles    []TileCollision
+}
+
+// names for the "collision" tile property
+var tileCollisionNames = map[string]TileCollision{
+	"":          TC_SOLID,
+	"solid":     TC_SOLID,
+	"oneway":    TC_ONEWAY,
+	"slopeup":   TC_SLOPE_UP,
+	"slopedown": TC_SLOPE_DOWN,
+	"none":      TC_EMPTY,
+}
+
+// build the collision for a map from one of its layers. every tile in the layer
+// is solid unless its tileset gives it a "collision" property saying otherwise.
+// tiles are as big in the world as they are in the map
+func NewCollisionMap(m *tmx.Map, layerName string) *CollisionMap {
+	cm := &CollisionMap{
+		Width:    m.Width,
+		Height:   m.Height,
+		TileSize: Fx(m.TileWidth),
+		Tiles:    make([]TileCollision, m.Width*m.Height),
+	}
+
+	var layer *tmx.Layer
+	for i := range m.Layers {
+		if m.Layers[i].Name == layerName {
+			layer = &m.Layers[i]
+		}
+	}
+	if layer == nil {
+		return cm
+	}
+
+	for i, tile := range layer.DecodedTiles {
+		if tile.IsNil() {
+			continue
+		}
+		cm.Tiles[i] = tileCollision(tile)
+	}
+
+	return cm
+}
+
+func tileCollision(tile *tmx.DecodedTile) TileCollision {
+	for _, t := range tile.Tileset.Tiles {
+		if t.ID != tile.ID {
+			continue
+		}
+
+		for _, p := range t.Properties {
+			if p.Name != "collision" {
+				continue
+			}
+			if tc, ok := tileCollisionNames[p.Value]; ok {
+				return tc
+			}
+		}
+	}
+
+	return TC_SOLID
+}
+
+// the collision at a tile. off the sides of the map is solid so nothing can
+// leave, off the top and bottom is open
+func (cm *CollisionMap) At(col, row int) TileCollision {
+	if col < 0 || col >= cm.Width {
+		return TC_SOLID
+	}
+	if row < 0 || row >= cm.Height {
+		return TC_EMPTY
+	}
+	return cm.Tiles[row*cm.Width+col]
+}
+
+// which tile a world position falls in
+func (cm *CollisionMap) tile(f Fixed) int {
+	return int(f.Div(cm.TileSize).Int())
+}
+
+// the height of the floor at x inside a tile, if the tile has one there
+func (cm *CollisionMap) surface(tc TileCollision, col, row int, x Fixed) (Fixed, bool) {
+	top := Fixed(row) * cm.TileSize
+	into := x - Fixed(col)*cm.TileSize
+
+	switch tc {
+	case TC_SOLID, TC_ONEWAY:
+		return top, true
+	case TC_SLOPE_UP:
+		return top + cm.TileSize - into, true
+	case TC_SLOPE_DOWN:
+		return top + into, true
+	}
+	return 0, false
+}
+
+// apply gravity and move an entity by its velocity, stopping it at anything
+// solid in the map. x and y are resolved separately so it slides along walls
+// and floors instead of sticking to them
+func (cm *CollisionMap) Move(ent *Entity) {
+	b := &ent.Body
+	wasOnGround := b.OnGround()
+	b.Contacts = 0
+
+	ent.Vel.Y += b.Gravity
+	if b.MaxFall > 0 && ent.Vel.Y > b.MaxFall {
+		ent.Vel.Y = b.MaxFall
+	}
+
+	if !b.Collide {
+		ent.Pos = ent.Pos.Add(ent.Vel)
+		return
+	}
+
+	cm.moveX(ent, wasOnGround)
+	cm.moveY(ent)
+	cm.settleFeet(ent, wasOnGround)
+}
+
+// true if a tile stops something moving sideways into it. on the ground,
+// anything whose top is less than half a tile above the feet can be stepped
+// onto, which is what lets entities walk up slopes
+func (cm *CollisionMap) blocksX(col, row int, feet Fixed, onGround bool) bool {
+	if cm.At(col, row) != TC_SOLID {
+		return false
+	}
+	return !onGround || feet-Fixed(row)*cm.TileSize > cm.TileSize/2
+}
+
+func (cm *CollisionMap) moveX(ent *Entity, onGround bool) {
+	dx := ent.Vel.X
+	if dx == 0 {
+		return
+	}
+
+	w, h := Fx(int(ent.Size.W)), Fx(int(ent.Size.H))
+	feet := ent.Pos.Y + h
+	row0, row1 := cm.tile(ent.Pos.Y), cm.tile(feet-1)
+
+	if dx > 0 {
+		// check every column the right edge moves into
+		right := ent.Pos.X + w
+		for col := cm.tile(right-1) + 1; col <= cm.tile(right+dx-1); col++ {
+			for row := row0; row <= row1; row++ {
+				if cm.blocksX(col, row, feet, onGround) {
+					ent.Pos.X = Fixed(col)*cm.TileSize - w
+					ent.Vel.X = 0
+					ent.Body.Contacts |= CT_WALL_RIGHT
+					return
+				}
+			}
+		}
+	} else {
+		left := ent.Pos.X
+		for col := cm.tile(left) - 1; col >= cm.tile(left+dx); col-- {
+			for row := row0; row <= row1; row++ {
+				if cm.blocksX(col, row, feet, onGround) {
+					ent.Pos.X = Fixed(col+1) * cm.TileSize
+					ent.Vel.X = 0
+					ent.Body.Contacts |= CT_WALL_LEFT
+					return
+				}
+			}
+		}
+	}
+
+	ent.Pos.X += dx
+}
+
+func (cm *CollisionMap) moveY(ent *Entity) {
+	dy := ent.Vel.Y
+	if dy == 0 {
+		return
+	}
+
+	w, h := Fx(int(ent.Size.W)), Fx(int(ent.Size.H))
+	col0, col1 := cm.tile(ent.Pos.X), cm.tile(ent.Pos.X+w-1)
+
+	if dy > 0 {
+		// check every row the feet move into. one way platforms only count if
+		// we started above them, which is always true for a row we're entering
+		feet := ent.Pos.Y + h
+		for row := cm.tile(feet-1) + 1; row <= cm.tile(feet+dy-1); row++ {
+			for col := col0; col <= col1; col++ {
+				tc := cm.At(col, row)
+				if tc == TC_SOLID || (tc == TC_ONEWAY && !ent.Body.DropThrough) {
+					ent.Pos.Y = Fixed(row)*cm.TileSize - h
+					ent.Vel.Y = 0
+					ent.Body.Contacts |= CT_GROUND
+					return
+				}
+			}
+		}
+	} else {
+		// slopes are solid from underneath
+		top := ent.Pos.Y
+		for row := cm.tile(top) - 1; row >= cm.tile(top+dy); row-- {
+			for col := col0; col <= col1; col++ {
+				tc := cm.At(col, row)
+				if tc == TC_SOLID || tc == TC_SLOPE_UP || tc == TC_SLOPE_DOWN {
+					ent.Pos.Y = Fixed(row+1) * cm.TileSize
+					ent.Vel.Y = 0
+					ent.Body.Contacts |= CT_CEILING
+					return
+				}
+			}
+		}
+	}
+
+	ent.Pos.Y += dy
+}
+
+// slopes only push on the middle of an entity's feet. lift it up out of any
+// slope it sank into, and keep it stuck to the ground when walking down one
+// instead of bouncing off the surface every step
+func (cm *CollisionMap) settleFeet(ent *Entity, wasOnGround bool) {
+	if ent.Vel.Y < 0 {
+		return
+	}
+
+	w, h := Fx(int(ent.Size.W)), Fx(int(ent.Size.H))
+	x, feet := ent.Pos.X+w/2, ent.Pos.Y+h
+	col, row := cm.tile(x), cm.tile(feet-1)
+
+	surface, ok := cm.floor(ent, col, row, x, wasOnGround)
+	if !ok && wasOnGround {
+		surface, ok = cm.floor(ent, col, row+1, x, wasOnGround)
+		if ok && surface-feet > cm.TileSize/2 {
+			ok = false
+		}
+	}
+	if !ok {
+		return
+	}
+
+	// in the air we only ever land, and anything we've sunk a long way into
+	// must have been come at from the side
+	if !wasOnGround && (surface > feet || feet-surface > cm.TileSize/2) {
+		return
+	}
+
+	ent.Pos.Y = surface - h
+	ent.Vel.Y = 0
+	ent.Body.Contacts |= CT_GROUND
+}
+
+// the floor under the feet in a tile. one way platforms only count when we were
+// already standing on them, not when walking into one from the side, and moveY
+// handles landing on them
+func (cm *CollisionMap) floor(ent *Entity, col, row int, x Fixed, wasOnGround bool) (Fixed, bool) {
+	tc := cm.At(col, row)
+	feet := ent.Pos.Y + Fx(int(ent.Size.H))
+	if tc == TC_ONEWAY && (ent.Body.DropThrough || !wasOnGround || Fixed(row)*cm.TileSize < feet) {
+		return 0, false
+	}
+	return cm.surface(tc, col, row, x)
+}
@@ -0,0 +1,204 @@
+package main
+
+import "testing"
+
+// a collision map drawn as text, one string per row of 16 pixel tiles:
+// # solid, - one way, / slope up, \ slope down, anything else empty
+func testCollisionMap(rows ...string) *CollisionMap {
+	cm := &CollisionMap{Width: len(rows[0]), Height: len(rows), TileSize: Fx(16)}
+	cm.Tiles = make([]TileCollision, cm.Width*cm.Height)
+	for row, line := range rows {
+		for col, c := range line {
+			var tc TileCollision
+			switch c {
+			case '#':
+				tc = TC_SOLID
+			case '-':
+				tc = TC_ONEWAY
+			case '/':
+				tc = TC_SLOPE_UP
+			case '\\':
+				tc = TC_SLOPE_DOWN
+			}
+			cm.Tiles[row*cm.Width+col] = tc
+		}
+	}
+	return cm
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     []string
+		x, y     int // top left of a 16x32 entity, in pixels
+		vx, vy   Fixed
+		drop     bool // hold down to fall through one way platforms
+		steps    int
+		onGround bool // standing when it starts
+		wantX    int
+		wantY    int
+		contacts Contacts
+	}{
+		{
+			name: "wall hit",
+			rows: []string{
+				"......",
+				"....#.",
+				"....#.",
+				"######",
+			},
+			x: 16, y: 16, vx: Fx(3), steps: 20, onGround: true,
+			wantX: 48, wantY: 16, contacts: CT_GROUND | CT_WALL_RIGHT,
+		},
+		{
+			// far more than a tile in one step still stops at the first wall
+			name: "fast wall hit",
+			rows: []string{
+				"..........",
+				"......#...",
+				"......#...",
+				"##########",
+			},
+			x: 16, y: 16, vx: Fx(100), steps: 1, onGround: true,
+			wantX: 80, wantY: 16, contacts: CT_GROUND | CT_WALL_RIGHT,
+		},
+		{
+			name: "wall on the left",
+			rows: []string{
+				"......",
+				"#.....",
+				"#.....",
+				"######",
+			},
+			x: 48, y: 16, vx: -Fx(3), steps: 20, onGround: true,
+			wantX: 16, wantY: 16, contacts: CT_GROUND | CT_WALL_LEFT,
+		},
+		{
+			name: "land on a one way platform",
+			rows: []string{
+				"......",
+				"......",
+				"......",
+				"..--..",
+				"......",
+				"######",
+			},
+			x: 32, y: 0, vy: Fx(2), steps: 30,
+			wantX: 32, wantY: 16, contacts: CT_GROUND,
+		},
+		{
+			name: "drop through a one way platform",
+			rows: []string{
+				"......",
+				"......",
+				"..--..",
+				"......",
+				"......",
+				"######",
+			},
+			x: 32, y: 0, drop: true, steps: 60, onGround: true,
+			wantX: 32, wantY: 48, contacts: CT_GROUND,
+		},
+		{
+			name: "jump up through a one way platform",
+			rows: []string{
+				"......",
+				"......",
+				"......",
+				"..--..",
+				"......",
+				"......",
+				"######",
+			},
+			x: 32, y: 64, vy: -Fx(5), steps: 16,
+			wantX: 32, wantY: 1,
+		},
+		{
+			// its top is level with our knees, so it isn't something to stand on
+			name: "walk into a one way platform from the side",
+			rows: []string{
+				"......",
+				"......",
+				"...--.",
+				"######",
+			},
+			x: 16, y: 16, vx: Fx(1), steps: 32, onGround: true,
+			wantX: 48, wantY: 16, contacts: CT_GROUND,
+		},
+		{
+			name: "walk up a slope",
+			rows: []string{
+				"......",
+				"......",
+				"......",
+				".../##",
+				"######",
+			},
+			x: 0, y: 32, vx: Fx(1), steps: 64, onGround: true,
+			wantX: 64, wantY: 16, contacts: CT_GROUND,
+		},
+		{
+			name: "walk down a slope",
+			rows: []string{
+				"......",
+				"......",
+				"##....",
+				"##\\...",
+				"######",
+			},
+			x: 16, y: 0, vx: Fx(1), steps: 48, onGround: true,
+			wantX: 64, wantY: 32, contacts: CT_GROUND,
+		},
+		{
+			name: "ceiling bump",
+			rows: []string{
+				"......",
+				"..##..",
+				"......",
+				"......",
+				"......",
+				"######",
+			},
+			x: 32, y: 48, vy: -Fx(6), steps: 3,
+			wantX: 32, wantY: 32, contacts: CT_CEILING,
+		},
+	}
+
+	for _, tt := range tests {
+		cm := testCollisionMap(tt.rows...)
+		ent := &Entity{
+			Pos:  Vec2{Fx(tt.x), Fx(tt.y)},
+			Vel:  Vec2{tt.vx, tt.vy},
+			Size: Size{16, 32},
+			Body: Body{Collide: true, Gravity: FIXED_ONE / 8, MaxFall: Fx(3), DropThrough: tt.drop},
+		}
+		if tt.onGround {
+			ent.Body.Contacts = CT_GROUND
+		}
+		for i := 0; i < tt.steps; i++ {
+			// walking keeps pushing, jumping only pushes the once
+			if tt.vx != 0 {
+				ent.Vel.X = tt.vx
+			}
+			cm.Move(ent)
+		}
+
+		pos := ent.Pos.Pixels()
+		if int(pos.X) != tt.wantX || int(pos.Y) != tt.wantY {
+			t.Errorf("%s: ended at %d,%d, want %d,%d", tt.name, pos.X, pos.Y, tt.wantX, tt.wantY)
+		}
+		if ent.Body.Contacts != tt.contacts {
+			t.Errorf("%s: contacts %04b, want %04b", tt.name, ent.Body.Contacts, tt.contacts)
+		}
+	}
+}
+
+// without Collide an entity goes wherever its velocity takes it
+func TestMoveNoCollide(t *testing.T) {
+	cm := testCollisionMap("###", "###")
+	ent := &Entity{Pos: Vec2{Fx(8), Fx(8)}, Vel: Vec2{Fx(2), Fx(1)}, Size: Size{4, 4}}
+	cm.Move(ent)
+	if ent.Pos != (Vec2{Fx(10), Fx(9)}) || ent.Body.Contacts != 0 {
+		t.Errorf("ended at %v with contacts %04b", ent.Pos.Pixels(), ent.Body.Contacts)
+	}
+}
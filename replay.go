@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// a recording is a header saying how to start the game the same way again,
+// then one record for every step:
+//
+//	flags    byte, REC_SAME if the command is the same as last step's
+//	command  Up Down Left Right as bytes, then Buttons as a uvarint and Mouse
+//	         X and Y as varints, left out when REC_SAME is set
+//	checksum uint32 of the state after the step
+//
+// players mostly hold the same thing down for a while, so most steps are
+// five bytes
+const (
+	REPLAY_MAGIC   = "GGRP"
+	REPLAY_VERSION = 1
+
+	REC_SAME = 1 << 0
+)
+
+var ErrNotReplay = errors.New("not a recording")
+
+// ReplayHeader is everything that has to match to get the same game again
+type ReplayHeader struct {
+	Level    string // in the base folder
+	Seed     uint32
+	TickRate int
+}
+
+// DesyncError is when playing back a recording didn't end up in the state it
+// did when it was recorded
+type DesyncError struct {
+	Tick uint64
+	Want uint32
+	Got  uint32
+}
+
+func (e *DesyncError) Error() string {
+	return fmt.Sprintf("replay desynced on tick %d: checksum %08x, recorded %08x", e.Tick, e.Got, e.Want)
+}
+
+// a checksum of everything in the state that the simulation depends on. the
+// camera is left out since its size comes from the window, which doesn't
+// have to match between recording and playing back, and so is the colour,
+// which is only for drawing
+func (st *GameState) Checksum() uint32 {
+	h := fnv.New32a()
+	w := func(v interface{}) {
+		binary.Write(h, binary.LittleEndian, v)
+	}
+
+	w(st.Tick)
+	w(st.Rand)
+	w(st.LocalEnt)
+	w(st.Camera.Trauma)
+	for _, slot := range st.Entities.LiveSlots() {
+		ent := &st.Entities.Ents[slot]
+		if !ent.Valid {
+			continue
+		}
+		w(ent.Id)
+		w(ent.Type)
+		w(ent.Variant)
+		w(ent.State)
+		w(ent.Timer)
+		w(ent.Dir)
+		w(ent.Pos)
+		w(ent.Vel)
+		w(ent.Size)
+		w(ent.Anim)
+		w(ent.Body)
+		w(ent.Group)
+		w(ent.Mask)
+		w(ent.Held)
+		w(ent.Inv)
+	}
+	return h.Sum32()
+}
+
+// Recorder writes a recording as the game is played
+type Recorder struct {
+	f    *os.File
+	w    *bufio.Writer
+	prev UserCommand
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func NewRecorder(fname string, hdr ReplayHeader) (*Recorder, error) {
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{f: f, w: bufio.NewWriter(f)}
+	r.w.WriteString(REPLAY_MAGIC)
+	binary.Write(r.w, binary.LittleEndian, uint16(REPLAY_VERSION))
+	binary.Write(r.w, binary.LittleEndian, uint16(hdr.TickRate))
+	binary.Write(r.w, binary.LittleEndian, hdr.Seed)
+	r.uvarint(uint64(len(hdr.Level)))
+	r.w.WriteString(hdr.Level)
+
+	return r, nil
+}
+
+// write down one step's command and the checksum of the state it led to
+func (r *Recorder) Record(cmd UserCommand, sum uint32) error {
+	if cmd == r.prev {
+		r.w.WriteByte(REC_SAME)
+	} else {
+		r.w.WriteByte(0)
+		r.w.Write([]byte{axisByte(cmd.Up), axisByte(cmd.Down), axisByte(cmd.Left), axisByte(cmd.Right)})
+		r.uvarint(uint64(cmd.Buttons))
+		r.varint(int64(cmd.Mouse.X))
+		r.varint(int64(cmd.Mouse.Y))
+		r.prev = cmd
+	}
+	return binary.Write(r.w, binary.LittleEndian, sum)
+}
+
+func (r *Recorder) Close() error {
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *Recorder) uvarint(v uint64) {
+	r.w.Write(r.buf[:binary.PutUvarint(r.buf[:], v)])
+}
+
+func (r *Recorder) varint(v int64) {
+	r.w.Write(r.buf[:binary.PutVarint(r.buf[:], v)])
+}
+
+func axisByte(v int) byte {
+	return byte(clamp(0, v, 255))
+}
+
+// Replay reads back a recording a step at a time
+type Replay struct {
+	Header ReplayHeader
+	Tick   uint64 // steps read so far
+	f      *os.File
+	r      *bufio.Reader
+	prev   UserCommand
+}
+
+func OpenReplay(fname string) (*Replay, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &Replay{f: f, r: bufio.NewReader(f)}
+	if err := rp.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	return rp, nil
+}
+
+func (rp *Replay) readHeader() error {
+	var head struct {
+		Magic    [4]byte
+		Version  uint16
+		TickRate uint16
+		Seed     uint32
+	}
+	if err := binary.Read(rp.r, binary.LittleEndian, &head); err != nil {
+		return err
+	}
+	if string(head.Magic[:]) != REPLAY_MAGIC {
+		return ErrNotReplay
+	}
+	if head.Version != REPLAY_VERSION {
+		return fmt.Errorf("recording version %d, can only play %d", head.Version, REPLAY_VERSION)
+	}
+
+	n, err := binary.ReadUvarint(rp.r)
+	if err != nil {
+		return err
+	}
+	level := make([]byte, n)
+	if _, err := io.ReadFull(rp.r, level); err != nil {
+		return err
+	}
+
+	rp.Header = ReplayHeader{Level: string(level), Seed: head.Seed, TickRate: int(head.TickRate)}
+	return nil
+}
+
+// the next step's command and the checksum it should come out to. the error
+// is io.EOF once the recording has run out
+func (rp *Replay) Next() (UserCommand, uint32, error) {
+	flags, err := rp.r.ReadByte()
+	if err != nil {
+		return UserCommand{}, 0, err
+	}
+
+	cmd := rp.prev
+	if flags&REC_SAME == 0 {
+		var axes [4]byte
+		if _, err := io.ReadFull(rp.r, axes[:]); err != nil {
+			return UserCommand{}, 0, truncated(err)
+		}
+		cmd = UserCommand{Up: int(axes[0]), Down: int(axes[1]), Left: int(axes[2]), Right: int(axes[3])}
+
+		buttons, err := binary.ReadUvarint(rp.r)
+		if err != nil {
+			return UserCommand{}, 0, truncated(err)
+		}
+		x, err := binary.ReadVarint(rp.r)
+		if err != nil {
+			return UserCommand{}, 0, truncated(err)
+		}
+		y, err := binary.ReadVarint(rp.r)
+		if err != nil {
+			return UserCommand{}, 0, truncated(err)
+		}
+		cmd.Buttons = uint32(buttons)
+		cmd.Mouse = Vector{int32(x), int32(y)}
+	}
+
+	var sum uint32
+	if err := binary.Read(rp.r, binary.LittleEndian, &sum); err != nil {
+		return UserCommand{}, 0, truncated(err)
+	}
+
+	rp.prev = cmd
+	rp.Tick++
+	return cmd, sum, nil
+}
+
+func (rp *Replay) Close() error {
+	return rp.f.Close()
+}
+
+// running out part way through a step isn't the normal end of a recording
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// play a recording through the simulation as fast as it goes, without an
+// engine, checking every step comes out the same as it did when it was
+// recorded. returns how many steps it got through
+func VerifyReplay(fname string) (uint64, error) {
+	rp, err := OpenReplay(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer rp.Close()
+
+	level, err := LoadLevel("base/" + rp.Header.Level)
+	if err != nil {
+		return 0, err
+	}
+
+	s := &GameScene{Level: rp.Header.Level, Seed: rp.Header.Seed, TickRate: rp.Header.TickRate}
+	s.sprites, err = LoadSprites("base/" + SPRITES_FILE)
+	if err != nil {
+		return 0, err
+	}
+	s.setup(level)
+
+	for {
+		cmd, want, err := rp.Next()
+		if err == io.EOF {
+			return rp.Tick, nil
+		}
+		if err != nil {
+			return rp.Tick, err
+		}
+
+		s.update(cmd)
+		if got := s.state.Checksum(); got != want {
+			return rp.Tick, &DesyncError{Tick: s.state.Tick, Want: want, Got: got}
+		}
+	}
+}
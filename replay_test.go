@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// what a player might do over a few seconds, one command per step
+func scriptedCommand(i int) UserCommand {
+	var cmd UserCommand
+	switch {
+	case i%240 < 120:
+		cmd.Right = 255
+	case i%240 < 200:
+		cmd.Left = 180
+	}
+	if i%90 < 20 {
+		cmd.Buttons |= BT_JUMP
+	}
+	if i%150 == 0 {
+		cmd.Buttons |= BT_SHOOT
+	}
+	if i%400 == 300 {
+		cmd.Buttons |= BT_POGO
+	}
+	cmd.Mouse = Vector{int32(i % 320), 100}
+	return cmd
+}
+
+// play the scripted commands on the test level, writing a recording the way
+// GameScene.tick does. the level and sprites come from the base folder
+func recordScript(t *testing.T, fname string, steps int) {
+	hdr := ReplayHeader{Level: DEFAULT_LEVEL, Seed: 1234, TickRate: DEFAULT_TICKRATE}
+	level, err := LoadLevel("base/" + hdr.Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GameScene{Level: hdr.Level, Seed: hdr.Seed, TickRate: hdr.TickRate}
+	if s.sprites, err = LoadSprites("base/" + SPRITES_FILE); err != nil {
+		t.Fatal(err)
+	}
+	s.setup(level)
+
+	rec, err := NewRecorder(fname, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < steps; i++ {
+		cmd := scriptedCommand(i)
+		s.update(cmd)
+		if err := rec.Record(cmd, s.state.Checksum()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempRecording(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test.rec"), func() { os.RemoveAll(dir) }
+}
+
+func TestReplayVerifies(t *testing.T) {
+	fname, done := tempRecording(t)
+	defer done()
+
+	const steps = 1200
+	recordScript(t, fname, steps)
+	ticks, err := VerifyReplay(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticks != steps {
+		t.Errorf("verified %d steps, recorded %d", ticks, steps)
+	}
+}
+
+func TestReplayDesync(t *testing.T) {
+	fname, done := tempRecording(t)
+	defer done()
+
+	recordScript(t, fname, 300)
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last thing in the file is the checksum for the last step
+	data[len(data)-1] ^= 0xff
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyReplay(fname)
+	if de, ok := err.(*DesyncError); !ok || de.Tick != 300 {
+		t.Errorf("got %v, want a desync on tick 300", err)
+	}
+
+	// cut off part way through a step
+	if err := ioutil.WriteFile(fname, data[:len(data)-2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyReplay(fname); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v for a cut off recording", err)
+	}
+}
+
+// everything the simulation carries from one step to the next has to change
+// the checksum, or a desync there would go unnoticed
+func TestChecksumCoversEntity(t *testing.T) {
+	var st GameState
+	ent := st.Entities.Spawn(ET_YORP)
+	base := st.Checksum()
+
+	changes := map[string]func(*Entity){
+		"contacts":     func(e *Entity) { e.Body.Contacts = CT_GROUND },
+		"drop through": func(e *Entity) { e.Body.DropThrough = true },
+		"gravity":      func(e *Entity) { e.Body.Gravity = 1 },
+		"frame":        func(e *Entity) { e.Anim.Frame = 1 },
+		"anim time":    func(e *Entity) { e.Anim.Time = 1 },
+		"group":        func(e *Entity) { e.Group = 1 },
+		"mask":         func(e *Entity) { e.Mask = 1 },
+		"timer":        func(e *Entity) { e.Timer = 1 },
+		"inventory":    func(e *Entity) { e.Inv.Ammo = 1 },
+	}
+	for name, change := range changes {
+		saved := *ent
+		change(ent)
+		if st.Checksum() == base {
+			t.Errorf("changing %s didn't change the checksum", name)
+		}
+		*ent = saved
+	}
+
+	// colour is only for drawing
+	ent.Color = RGBA{1, 2, 3, 4}
+	if st.Checksum() != base {
+		t.Error("colour changed the checksum")
+	}
+}
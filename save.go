@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saves are JSON so they can be looked at and fixed up by hand, and so old
+// ones can be brought up to date without keeping the old types around. only
+// the live entities are written out, along with what the entity list needs
+// to hand out the same slots and ids it would have if the game had carried on
+const (
+	SAVE_VERSION   = 1
+	SAVE_DIR       = "base/saves"
+	QUICKSAVE_SLOT = 0
+)
+
+var ErrSaveVersion = errors.New("save is from a newer version of the game")
+
+// SaveFile is everything needed to carry on a game from where it was saved
+type SaveFile struct {
+	Version  int           `json:"version"`
+	Level    string        `json:"level"`
+	Saved    time.Time     `json:"saved"`
+	Tick     uint64        `json:"tick"`
+	Rand     uint32        `json:"rand"`
+	LocalEnt EntityId      `json:"localEnt"`
+	Camera   Camera        `json:"camera"`
+	Entities []SavedEntity `json:"entities"` // in the order they think
+	Gens     []uint16      `json:"gens"`     // of every slot that has been used
+	Free     []uint16      `json:"free"`
+}
+
+type SavedEntity struct {
+	Slot uint16 `json:"slot"`
+	Entity
+}
+
+// SaveMigration brings a save decoded as plain JSON up by one version. a
+// field that's new can usually be left out for it to load as zero, so these
+// are only needed when something was renamed or changed meaning
+type SaveMigration func(save map[string]interface{}) error
+
+// saveMigrations[i] takes a version i+1 save to version i+2, so there's
+// always one less of these than SAVE_VERSION
+var saveMigrations []SaveMigration
+
+// the file a save slot is kept in
+func SaveSlotFile(slot int) string {
+	return fmt.Sprintf("%s/slot%d.json", SAVE_DIR, slot)
+}
+
+func NewSaveFile(level string, st *GameState) *SaveFile {
+	ents := &st.Entities
+	sv := &SaveFile{
+		Version:  SAVE_VERSION,
+		Level:    level,
+		Saved:    time.Now(),
+		Tick:     st.Tick,
+		Rand:     st.Rand,
+		LocalEnt: st.LocalEnt,
+		Camera:   st.Camera,
+		Entities: []SavedEntity{},
+		Gens:     append([]uint16{}, ents.Gens[:ents.Used+1]...),
+		Free:     append([]uint16{}, ents.Free[:ents.NumFree]...),
+	}
+	for _, slot := range ents.LiveSlots() {
+		if ents.Ents[slot].Valid {
+			sv.Entities = append(sv.Entities, SavedEntity{slot, ents.Ents[slot]})
+		}
+	}
+	return sv
+}
+
+// the state the save was made from, or an error if the save doesn't make sense.
+// animations are checked against the sprites they'll be played from
+func (sv *SaveFile) State(sprites *SpriteSet) (GameState, error) {
+	st := GameState{Tick: sv.Tick, Rand: sv.Rand, LocalEnt: sv.LocalEnt, Camera: sv.Camera}
+	ents := &st.Entities
+	if len(sv.Gens) == 0 || len(sv.Gens) > MAX_ENTITIES || len(sv.Free) >= len(sv.Gens) {
+		return st, errors.New("entity slots don't add up")
+	}
+	ents.Used = int32(len(sv.Gens) - 1)
+	copy(ents.Gens[:], sv.Gens)
+
+	for _, se := range sv.Entities {
+		slot := se.Slot
+		if slot == 0 || int32(slot) > ents.Used || ents.Ents[slot].Valid || se.Id.slot() != int(slot) || !se.Valid {
+			return st, fmt.Errorf("entity %x doesn't fit in slot %d", se.Id, slot)
+		}
+		ents.Ents[slot] = se.Entity
+		ents.Live[ents.NumLive] = slot
+		ents.NumLive++
+	}
+	for _, slot := range sv.Free {
+		ents.Free[ents.NumFree] = slot
+		ents.NumFree++
+	}
+	return st, st.Validate(sprites)
+}
+
+func WriteSave(fname string, sv *SaveFile) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sv, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// a save that only got half written would lose the one before it too
+	tmp := fname + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// read a save, bringing it up to date first if it's from an older version
+func ReadSave(fname string) (*SaveFile, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers stay as they were written, ticks can be too big for a float64
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	num, _ := raw["version"].(json.Number)
+	version, err := num.Int64()
+	if err != nil || version < 1 {
+		return nil, fmt.Errorf("%s: no version", fname)
+	}
+	if version > SAVE_VERSION {
+		return nil, fmt.Errorf("%s: %s", fname, ErrSaveVersion)
+	}
+
+	if version < SAVE_VERSION {
+		for v := version; v < SAVE_VERSION; v++ {
+			if err := saveMigrations[v-1](raw); err != nil {
+				return nil, fmt.Errorf("%s: from version %d: %s", fname, v, err)
+			}
+		}
+		raw["version"] = SAVE_VERSION
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	sv := &SaveFile{}
+	if err := json.Unmarshal(data, sv); err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	return sv, nil
+}
+
+// write the game as it is now to a slot
+func (s *GameScene) SaveGame(slot int) error {
+	return WriteSave(SaveSlotFile(slot), NewSaveFile(s.Level, &s.state))
+}
+
+// carry on from a save slot. a save from another level is handed to a new
+// scene for that level, since this one can't change level under the engine
+// while it's being drawn
+func (s *GameScene) LoadGame(slot int) error {
+	fname := SaveSlotFile(slot)
+	sv, err := ReadSave(fname)
+	if err != nil {
+		return err
+	}
+	st, err := sv.State(s.sprites)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fname, err)
+	}
+
+	if sv.Level != s.Level {
+		next := &GameScene{NoLerp: s.NoLerp, TickRate: s.TickRate, MaxSteps: s.MaxSteps, Camera: s.Camera, Resume: sv, zoom: s.zoom}
+		s.sch.Eng <- EngineCommand{Id: EC_REPLACESCENE, Data: SceneRequest{Scene: next, Transition: TR_FADE}}
+		<-s.sch.Eng
+		return nil
+	}
+
+	s.resume(st)
+	return nil
+}
+
+// carry on from a saved state on the level this scene has set up. the camera
+// stays the size it is now
+func (s *GameScene) resume(st GameState) {
+	s.state = st
+	s.state.Camera.SetSize(s.screen)
+	s.prevState = s.state
+	s.contacts = Broadphase{}
+}
+
+// save and load actions. neither can be done part way through a recording,
+// which only has the commands, or when the server has the real game
+func (s *GameScene) quickSaveLoad() {
+	var err error
+	switch {
+	case !s.input.Pressed(ACT_QUICKSAVE) && !s.input.Pressed(ACT_QUICKLOAD):
+		return
+	case s.client != nil:
+		err = errors.New("not while playing on a server")
+	case s.recorder != nil || s.replay != nil:
+		err = errors.New("not while recording or playing back")
+	case s.input.Pressed(ACT_QUICKSAVE):
+		err = s.SaveGame(QUICKSAVE_SLOT)
+	default:
+		err = s.LoadGame(QUICKSAVE_SLOT)
+	}
+
+	if err != nil {
+		fmt.Printf("Quicksave: %s\n", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// run the test from an empty folder so saves don't go in the real one
+func inTempDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "saves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func newSaveScene(t *testing.T) *GameScene {
+	s := newTestScene(t,
+		testObject("player_start", 16, 32),
+		testObject("EntityYorp", 200, 24),
+		testObject("EntityRaygun", 60, 16),
+	)
+	s.Level = "test"
+	return s
+}
+
+func TestSaveRoundTrip(t *testing.T) {
+	defer inTempDir(t)()
+	s := newSaveScene(t)
+	stepScene(s, UserCommand{Right: 255}, 100)
+
+	if err := s.SaveGame(1); err != nil {
+		t.Fatal(err)
+	}
+	saved := s.state
+
+	// how it goes without stopping
+	stepScene(s, UserCommand{Right: 255}, 50)
+	want := s.state.Checksum()
+
+	if err := s.LoadGame(1); err != nil {
+		t.Fatal(err)
+	}
+	// only what's alive is saved, so dead slots can be left with anything
+	// in them and are compared by what the simulation sees
+	ents, had := &s.state.Entities, &saved.Entities
+	if s.state.Checksum() != saved.Checksum() || s.state.Camera != saved.Camera ||
+		ents.Gens != had.Gens || ents.NumFree != had.NumFree || ents.Free != had.Free {
+		t.Fatal("loaded state isn't the one that was saved")
+	}
+
+	// and it carries on the same way from there
+	stepScene(s, UserCommand{Right: 255}, 50)
+	if s.state.Checksum() != want {
+		t.Error("game went differently after loading")
+	}
+}
+
+// what was touching before a load mustn't carry over into the loaded game
+func TestLoadForgetsContacts(t *testing.T) {
+	defer inTempDir(t)()
+	s := newSaveScene(t)
+
+	// saved with the player well away from the yorp
+	if err := s.SaveGame(1); err != nil {
+		t.Fatal(err)
+	}
+	yorp := s.find(ET_YORP)
+	p := s.player()
+	p.Pos = yorp.Pos
+	s.update(UserCommand{})
+	if len(s.contacts.pairs) == 0 {
+		t.Fatal("player isn't touching the yorp")
+	}
+
+	if err := s.LoadGame(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.contacts.pairs) != 0 {
+		t.Errorf("%d contacts left over from before loading", len(s.contacts.pairs))
+	}
+}
+
+func TestLoadOtherLevelReplacesScene(t *testing.T) {
+	defer inTempDir(t)()
+	other := newSaveScene(t)
+	other.Level = "other"
+	if err := other.SaveGame(2); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSaveScene(t)
+	before := s.state
+	s.sch.Eng = make(chan EngineCommand)
+	got := make(chan EngineCommand, 1)
+	go func() {
+		cmd := <-s.sch.Eng
+		got <- cmd
+		s.sch.Eng <- EngineCommand{Id: cmd.Id, Success: true}
+	}()
+
+	if err := s.LoadGame(2); err != nil {
+		t.Fatal(err)
+	}
+	cmd := <-got
+	req, ok := cmd.Data.(SceneRequest)
+	if cmd.Id != EC_REPLACESCENE || !ok {
+		t.Fatalf("asked the engine for %+v", cmd)
+	}
+	next, ok := req.Scene.(*GameScene)
+	if !ok || next.Resume == nil || next.Resume.Level != "other" {
+		t.Fatalf("replaced with %+v", req.Scene)
+	}
+	if s.state.Checksum() != before.Checksum() {
+		t.Error("scene changed its own state for a save on another level")
+	}
+}
+
+func TestSaveStateChecks(t *testing.T) {
+	s := newSaveScene(t)
+	sprites := &SpriteSet{Sheets: []SpriteSheet{{Anims: []Animation{{Frames: []int{0, 1}}}}}}
+
+	bad := map[string]func(*SaveFile){
+		"type":    func(sv *SaveFile) { sv.Entities[0].Type = NUM_ENTITY_TYPES },
+		"sheet":   func(sv *SaveFile) { sv.Entities[0].Anim.Sheet = 2 },
+		"anim":    func(sv *SaveFile) { sv.Entities[0].Anim.Sheet, sv.Entities[0].Anim.Anim = 1, 1 },
+		"frame":   func(sv *SaveFile) { sv.Entities[0].Anim.Sheet, sv.Entities[0].Anim.Frame = 1, 2 },
+		"slot":    func(sv *SaveFile) { sv.Entities[0].Slot = MAX_ENTITIES - 1 },
+		"variant": func(sv *SaveFile) { sv.Entities[0].Variant = 1 },
+		"collectable": func(sv *SaveFile) {
+			sv.Entities[0].Type, sv.Entities[0].Variant = ET_COLLECTABLE, uint8(len(collectables))
+		},
+		"local entity": func(sv *SaveFile) { sv.LocalEnt = MAX_ENTITIES },
+		"free twice": func(sv *SaveFile) {
+			sv.Gens = append(sv.Gens, 1, 1)
+			sv.Free = []uint16{uint16(len(sv.Gens) - 1), uint16(len(sv.Gens) - 1)}
+		},
+		"live and free": func(sv *SaveFile) { sv.Free = []uint16{1, 2, 3} },
+	}
+	for name, change := range bad {
+		sv := NewSaveFile("test", &s.state)
+		change(sv)
+		if _, err := sv.State(sprites); err == nil {
+			t.Errorf("bad %s was loaded", name)
+		}
+	}
+
+	sv := NewSaveFile("test", &s.state)
+	sv.Entities[0].Anim = Animator{Sheet: 1, Frame: 1}
+	if _, err := sv.State(sprites); err != nil {
+		t.Error(err)
+	}
+
+	// without sprites nothing is drawn or animated, so there's nothing to check
+	if _, err := sv.State(nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Transition int
+
+const (
+	TR_NONE Transition = iota
+	TR_FADE
+	TR_CROSSFADE
+)
+
+const TRANSITION_TIME = 500 * time.Millisecond
+
+// errors a scene can send before it has to wait for the engine to read them,
+// so a level full of warnings doesn't hold up loading
+const SCENE_ERRORS = 32
+
+var ErrShutdownTimeout = errors.New("timed out waiting for scenes to finish")
+
+// sent with EC_PUSHSCENE and EC_REPLACESCENE. EC_POPSCENE only needs the Transition
+type SceneRequest struct {
+	Scene      Scene
+	Transition Transition
+}
+
+type sceneEntry struct {
+	scene  Scene
+	ch     SceneChannels
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// SceneManager owns the stack of scenes and any transition between them. it is
+// only touched from the engine thread. every scene runs in its own goroutine
+// which keeps going until the scene is popped or replaced and has finished
+// unloading. each scene's context is derived from the manager's, so cancelling
+// that stops everything
+type SceneManager struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stack   []*sceneEntry
+	running []*sceneEntry
+	screen  Size // what scenes draw to, every scene is told when it starts
+
+	trans      Transition
+	transFrom  *sceneEntry
+	transTo    *sceneEntry
+	transStart time.Time
+	transStop  bool
+}
+
+func NewSceneManager() *SceneManager {
+	sm := &SceneManager{}
+	sm.ctx, sm.cancel = context.WithCancel(context.Background())
+	return sm
+}
+
+// the scene on top of the stack, which is the one receiving input
+func (sm *SceneManager) Top() *sceneEntry {
+	if len(sm.stack) == 0 {
+		return nil
+	}
+	return sm.stack[len(sm.stack)-1]
+}
+
+// true once every scene has been popped and nothing is left to draw
+func (sm *SceneManager) Empty() bool {
+	return len(sm.stack) == 0 && sm.trans == TR_NONE
+}
+
+// start a scene on top of the current one. the scene underneath keeps running
+func (sm *SceneManager) Push(scene Scene, t Transition) {
+	from := sm.Top()
+	to := sm.start(scene)
+	sm.stack = append(sm.stack, to)
+	sm.beginTransition(t, from, to, false)
+}
+
+// stop the scene on top and go back to the one underneath
+func (sm *SceneManager) Pop(t Transition) {
+	from := sm.Top()
+	if from == nil {
+		return
+	}
+
+	sm.stack = sm.stack[:len(sm.stack)-1]
+	sm.beginTransition(t, from, sm.Top(), true)
+}
+
+// stop the scene on top and start a new one in its place
+func (sm *SceneManager) Replace(scene Scene, t Transition) {
+	from := sm.Top()
+	if from != nil {
+		sm.stack = sm.stack[:len(sm.stack)-1]
+	}
+
+	to := sm.start(scene)
+	sm.stack = append(sm.stack, to)
+	sm.beginTransition(t, from, to, true)
+}
+
+// tell every scene to quit and clear the stack. the scenes keep running until
+// they have finished unloading, use Running to see when they're all gone
+func (sm *SceneManager) StopAll() {
+	sm.trans, sm.transFrom, sm.transTo, sm.transStop = TR_NONE, nil, nil, false
+	sm.stack = sm.stack[:0]
+	sm.cancel()
+}
+
+// how many scene goroutines haven't finished yet
+func (sm *SceneManager) Running() int {
+	sm.reap()
+	return len(sm.running)
+}
+
+// forget about scenes whose goroutines have finished unloading
+func (sm *SceneManager) reap() {
+	n := 0
+	for _, e := range sm.running {
+		select {
+		case <-e.done:
+		default:
+			sm.running[n] = e
+			n++
+		}
+	}
+	sm.running = sm.running[:n]
+}
+
+func (sm *SceneManager) start(scene Scene) *sceneEntry {
+	ctx, cancel := context.WithCancel(sm.ctx)
+	e := &sceneEntry{
+		scene: scene,
+		ch: SceneChannels{
+			RCmd: make(chan *RenderCommandList, 1),
+			Ev:   NewEventQueue(),
+			Eng:  make(chan EngineCommand),
+			Err:  make(chan error, SCENE_ERRORS),
+			Ctx:  ctx,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	e.ch.Ev.Push(Event{Type: EV_RESIZE, EvData1: int(sm.screen.W), EvData2: int(sm.screen.H)})
+
+	go func() {
+		scene.Load(e.ch)
+		scene.Unload()
+		close(e.done)
+	}()
+
+	sm.running = append(sm.running, e)
+	return e
+}
+
+// tell every scene about a new screen size, including ones that aren't on top
+// since they'll be drawn again once the ones above them are gone
+func (sm *SceneManager) Resize(screen Size) {
+	sm.screen = screen
+	sm.Broadcast(Event{Type: EV_RESIZE, EvData1: int(screen.W), EvData2: int(screen.H)})
+}
+
+// send an event to every running scene
+func (sm *SceneManager) Broadcast(ev Event) {
+	for _, e := range sm.running {
+		e.ch.Ev.Push(ev)
+	}
+}
+
+// tell a scene to quit. the engine keeps answering its commands until it is done
+func (sm *SceneManager) stop(e *sceneEntry) {
+	e.cancel()
+}
+
+func (sm *SceneManager) beginTransition(t Transition, from, to *sceneEntry, stopFrom bool) {
+	// anything already in progress is cut short
+	sm.endTransition()
+
+	if t == TR_NONE {
+		if stopFrom && from != nil {
+			sm.stop(from)
+		}
+		return
+	}
+
+	sm.trans, sm.transFrom, sm.transTo, sm.transStop = t, from, to, stopFrom
+	sm.transStart = time.Time{}
+}
+
+func (sm *SceneManager) endTransition() {
+	if sm.trans == TR_NONE {
+		return
+	}
+
+	if sm.transStop && sm.transFrom != nil {
+		sm.stop(sm.transFrom)
+	}
+
+	sm.trans, sm.transFrom, sm.transTo, sm.transStop = TR_NONE, nil, nil, false
+}
+
+// how far through the current transition we are, from 0 to 1. the clock starts
+// the first time this is called, so the engine holds off until the incoming
+// scene is ready to draw
+func (sm *SceneManager) transitionProgress(now time.Time) float64 {
+	if sm.transStart.IsZero() {
+		sm.transStart = now
+	}
+
+	t := float64(now.Sub(sm.transStart)) / float64(TRANSITION_TIME)
+	if t > 1 {
+		t = 1
+	}
+	return t
+}
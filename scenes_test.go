@@ -0,0 +1,113 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// a scene that does nothing but wait to be cancelled
+type idleScene struct {
+	loaded   chan struct{}
+	unloaded chan struct{}
+}
+
+func newIdleScene() *idleScene {
+	return &idleScene{loaded: make(chan struct{}), unloaded: make(chan struct{})}
+}
+
+func (s *idleScene) Load(ch SceneChannels) {
+	close(s.loaded)
+	<-ch.Ctx.Done()
+}
+
+func (s *idleScene) Unload()                    { close(s.unloaded) }
+func (s *idleScene) Ready() bool                { return true }
+func (s *idleScene) Render() *RenderCommandList { return &RenderCommandList{} }
+
+// wait for the goroutine count to come back down to n, they take a moment
+// to actually exit after signalling they're done
+func waitGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left over, started with %d\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitRunning(t *testing.T, sm *SceneManager, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for sm.Running() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d scenes running, want %d", sm.Running(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScenesStopOnShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	sm := NewSceneManager()
+	scenes := []*idleScene{newIdleScene(), newIdleScene(), newIdleScene()}
+	for _, s := range scenes {
+		sm.Push(s, TR_NONE)
+		<-s.loaded
+	}
+	if n := sm.Running(); n != len(scenes) {
+		t.Fatalf("%d scenes running, want %d", n, len(scenes))
+	}
+
+	sm.StopAll()
+	waitRunning(t, sm, 0)
+	for i, s := range scenes {
+		select {
+		case <-s.unloaded:
+		default:
+			t.Errorf("scene %d wasn't unloaded", i)
+		}
+	}
+	waitGoroutines(t, before)
+}
+
+func TestScenePopCancels(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	sm := NewSceneManager()
+	bottom, top := newIdleScene(), newIdleScene()
+	sm.Push(bottom, TR_NONE)
+	sm.Push(top, TR_NONE)
+	<-top.loaded
+
+	// only the one popped stops
+	sm.Pop(TR_NONE)
+	select {
+	case <-top.unloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("popped scene didn't unload")
+	}
+	waitRunning(t, sm, 1)
+
+	sm.StopAll()
+	waitRunning(t, sm, 0)
+	waitGoroutines(t, before)
+}
+
+func TestLoaderWorkersStopOnClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	l := NewImageLoader(nil)
+	if runtime.NumGoroutine() < before+LOADER_WORKERS {
+		t.Fatalf("loader started %d goroutines, want %d", runtime.NumGoroutine()-before, LOADER_WORKERS)
+	}
+
+	// workers part way through decoding have to give up too
+	for i := 0; i < LOADER_WORKERS; i++ {
+		l.jobs <- "missing.png"
+	}
+	l.Close()
+	waitGoroutines(t, before)
+}
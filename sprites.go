@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type AnimMode uint8
+
+const (
+	AM_LOOP     AnimMode = iota // back to the first frame after the last
+	AM_PINGPONG                 // back and forth between the first and last frames
+	AM_ONCE                     // stop on the last frame
+)
+
+var animModeNames = map[string]AnimMode{
+	"":         AM_LOOP,
+	"loop":     AM_LOOP,
+	"pingpong": AM_PINGPONG,
+	"once":     AM_ONCE,
+}
+
+// how long a frame shows for when the sprite file doesn't say
+const DEFAULT_FRAME_TIME = 100 * time.Millisecond
+
+// SpriteFrame is the part of a sheet's image one frame is drawn from
+type SpriteFrame struct {
+	Pos  Vector
+	Size Size
+}
+
+type Animation struct {
+	Name      string
+	Frames    []int // into the sheet's Frames
+	Durations []time.Duration
+	Mode      AnimMode
+	Flip      bool // mirrored left to right
+}
+
+// SpriteSheet is an image cut up into frames, and the animations made out of them
+type SpriteSheet struct {
+	Name    string
+	Image   string
+	ImageId int32 // filled in by Bind once the image is loaded
+	Frames  []SpriteFrame
+	Anims   []Animation
+	byName  map[string]int
+}
+
+// SpriteSet is every sprite sheet the game knows about. sheets and animations
+// are numbered in name order so the numbers an Animator keeps mean the same
+// thing every time the file is loaded
+type SpriteSet struct {
+	Sheets []SpriteSheet
+	byName map[string]int
+}
+
+// Animator plays an animation from a SpriteSet. it is plain data so it can
+// live in an Entity, and only means something alongside the set it came from
+type Animator struct {
+	Sheet uint16 // one more than the sheet's index, zero for nothing to draw
+	Anim  uint16
+	Frame uint16 // into the animation's frames
+	Back  bool   // a ping pong animation on its way back to the start
+	Done  bool   // a once animation got to its last frame
+	Flip  bool   // mirror left to right, on top of any flip in the animation
+	Time  time.Duration
+}
+
+// how sprite sheets are written in the sprite file, which is an object of
+// sheets by name. frames are either cut from the image in a grid of
+// frameWidth by frameHeight, numbered left to right and top to bottom, or
+// listed in rects as [x, y, w, h]. durations are in milliseconds, and an
+// animation can give one duration for every frame or one each
+type spriteSheetDef struct {
+	Image       string                  `json:"image"`
+	FrameWidth  int32                   `json:"frameWidth"`
+	FrameHeight int32                   `json:"frameHeight"`
+	Rects       [][4]int32              `json:"rects"`
+	Animations  map[string]animationDef `json:"animations"`
+}
+
+type animationDef struct {
+	Frames    []int  `json:"frames"`
+	Duration  int    `json:"duration"`
+	Durations []int  `json:"durations"`
+	Mode      string `json:"mode"`
+	Flip      bool   `json:"flip"`
+}
+
+// read sprite sheet definitions. images are looked for next to the file
+func LoadSprites(fname string) (*SpriteSet, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var defs map[string]spriteSheetDef
+	if err := json.NewDecoder(f).Decode(&defs); err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ss := &SpriteSet{byName: make(map[string]int)}
+	for _, name := range names {
+		sheet, err := newSpriteSheet(name, defs[name], filepath.Dir(fname))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fname, err)
+		}
+		ss.byName[name] = len(ss.Sheets)
+		ss.Sheets = append(ss.Sheets, *sheet)
+	}
+
+	return ss, nil
+}
+
+func newSpriteSheet(name string, def spriteSheetDef, dir string) (*SpriteSheet, error) {
+	sheet := &SpriteSheet{Name: name, Image: def.Image, byName: make(map[string]int)}
+
+	for _, r := range def.Rects {
+		sheet.Frames = append(sheet.Frames, SpriteFrame{Vector{r[0], r[1]}, Size{r[2], r[3]}})
+	}
+
+	if len(sheet.Frames) == 0 {
+		if def.FrameWidth <= 0 || def.FrameHeight <= 0 {
+			return nil, fmt.Errorf("sheet %s needs rects or a frame size", name)
+		}
+
+		w, h, err := imageSize(filepath.Join(dir, def.Image))
+		if err != nil {
+			return nil, err
+		}
+		for y := int32(0); y+def.FrameHeight <= int32(h); y += def.FrameHeight {
+			for x := int32(0); x+def.FrameWidth <= int32(w); x += def.FrameWidth {
+				sheet.Frames = append(sheet.Frames, SpriteFrame{Vector{x, y}, Size{def.FrameWidth, def.FrameHeight}})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(def.Animations))
+	for anim := range def.Animations {
+		names = append(names, anim)
+	}
+	sort.Strings(names)
+
+	for _, anim := range names {
+		adef := def.Animations[anim]
+		mode, ok := animModeNames[adef.Mode]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: unknown mode %q", name, anim, adef.Mode)
+		}
+		if len(adef.Frames) == 0 {
+			return nil, fmt.Errorf("%s.%s: no frames", name, anim)
+		}
+		if len(adef.Durations) != 0 && len(adef.Durations) != len(adef.Frames) {
+			return nil, fmt.Errorf("%s.%s: %d durations for %d frames", name, anim, len(adef.Durations), len(adef.Frames))
+		}
+
+		a := Animation{Name: anim, Frames: adef.Frames, Mode: mode, Flip: adef.Flip}
+		for i, frame := range adef.Frames {
+			if frame < 0 || frame >= len(sheet.Frames) {
+				return nil, fmt.Errorf("%s.%s: no frame %d", name, anim, frame)
+			}
+
+			ms := adef.Duration
+			if len(adef.Durations) != 0 {
+				ms = adef.Durations[i]
+			}
+			d := time.Duration(ms) * time.Millisecond
+			if d <= 0 {
+				d = DEFAULT_FRAME_TIME
+			}
+			a.Durations = append(a.Durations, d)
+		}
+
+		sheet.byName[anim] = len(sheet.Anims)
+		sheet.Anims = append(sheet.Anims, a)
+	}
+
+	return sheet, nil
+}
+
+// every image the sheets are drawn from
+func (ss *SpriteSet) Images() []string {
+	var images []string
+	for i := range ss.Sheets {
+		images = append(images, ss.Sheets[i].Image)
+	}
+	return images
+}
+
+// remember the ids of the loaded images so frames can be drawn
+func (ss *SpriteSet) Bind(images map[string]Image) {
+	for i := range ss.Sheets {
+		ss.Sheets[i].ImageId = int32(images[ss.Sheets[i].Image].Id)
+	}
+}
+
+// the animation an animator is playing, or nil if any of its numbers don't
+// fit the set. animators come from saves and the network as well as from
+// Play, so nothing is indexed with them until they've been checked here
+func (ss *SpriteSet) anim(a *Animator) *Animation {
+	if ss == nil || a.Sheet == 0 || int(a.Sheet) > len(ss.Sheets) {
+		return nil
+	}
+	sheet := &ss.Sheets[a.Sheet-1]
+	if int(a.Anim) >= len(sheet.Anims) {
+		return nil
+	}
+	anim := &sheet.Anims[a.Anim]
+	if int(a.Frame) >= len(anim.Frames) {
+		return nil
+	}
+	return anim
+}
+
+// start playing an animation from a sheet. if it's already playing it carries
+// on where it was instead of starting over. anything that isn't in the set is
+// left alone, and a nil set does nothing so entities can run without sprites
+func (ss *SpriteSet) Play(a *Animator, sheet, anim string) {
+	if ss == nil {
+		return
+	}
+	si, ok := ss.byName[sheet]
+	if !ok {
+		return
+	}
+	ai, ok := ss.Sheets[si].byName[anim]
+	if !ok {
+		return
+	}
+
+	if int(a.Sheet) == si+1 && int(a.Anim) == ai && ss.anim(a) != nil {
+		return
+	}
+	*a = Animator{Sheet: uint16(si + 1), Anim: uint16(ai), Flip: a.Flip}
+}
+
+// true if the animator is playing anim from its sheet
+func (ss *SpriteSet) Playing(a *Animator, anim string) bool {
+	cur := ss.anim(a)
+	return cur != nil && cur.Name == anim
+}
+
+// move an animation on by dt
+func (ss *SpriteSet) Advance(a *Animator, dt time.Duration) {
+	anim := ss.anim(a)
+	if anim == nil {
+		return
+	}
+
+	a.Time += dt
+	for !a.Done && a.Time >= anim.Durations[a.Frame] {
+		a.Time -= anim.Durations[a.Frame]
+
+		last := uint16(len(anim.Frames) - 1)
+		switch anim.Mode {
+		case AM_LOOP:
+			a.Frame++
+			if a.Frame > last {
+				a.Frame = 0
+			}
+		case AM_ONCE:
+			if a.Frame < last {
+				a.Frame++
+			}
+			if a.Frame == last {
+				a.Done = true
+			}
+		case AM_PINGPONG:
+			if last == 0 {
+				break
+			}
+			if a.Frame == last {
+				a.Back = true
+			} else if a.Frame == 0 {
+				a.Back = false
+			}
+			if a.Back {
+				a.Frame--
+			} else {
+				a.Frame++
+			}
+		}
+	}
+}
+
+// the image and part of it to draw the current frame from, and whether to
+// mirror it. ok is false if there's nothing to draw
+func (ss *SpriteSet) Frame(a *Animator) (image int32, frame SpriteFrame, flip bool, ok bool) {
+	anim := ss.anim(a)
+	if anim == nil {
+		return 0, SpriteFrame{}, false, false
+	}
+
+	sheet := &ss.Sheets[a.Sheet-1]
+	return sheet.ImageId, sheet.Frames[anim.Frames[a.Frame]], anim.Flip != a.Flip, true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// one sheet of four frames with a three frame animation in each mode, every
+// frame 10ms long
+func testSprites() *SpriteSet {
+	sheet := SpriteSheet{
+		Name:   "test",
+		Frames: make([]SpriteFrame, 4),
+		byName: map[string]int{},
+	}
+	for i := range sheet.Frames {
+		sheet.Frames[i] = SpriteFrame{Vector{int32(i) * 16, 0}, Size{16, 16}}
+	}
+	for _, mode := range []string{"loop", "once", "pingpong"} {
+		sheet.byName[mode] = len(sheet.Anims)
+		sheet.Anims = append(sheet.Anims, Animation{
+			Name:      mode,
+			Frames:    []int{1, 2, 3},
+			Durations: []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond},
+			Mode:      animModeNames[mode],
+		})
+	}
+	sheet.byName["still"] = len(sheet.Anims)
+	sheet.Anims = append(sheet.Anims, Animation{
+		Name:      "still",
+		Frames:    []int{0},
+		Durations: []time.Duration{10 * time.Millisecond},
+		Mode:      AM_PINGPONG,
+	})
+	return &SpriteSet{Sheets: []SpriteSheet{sheet}, byName: map[string]int{"test": 0}}
+}
+
+func TestAnimationModes(t *testing.T) {
+	tests := []struct {
+		anim   string
+		frames []uint16 // after each 10ms
+		done   bool
+	}{
+		{"loop", []uint16{1, 2, 0, 1, 2, 0}, false},
+		{"once", []uint16{1, 2, 2, 2}, true},
+		{"pingpong", []uint16{1, 2, 1, 0, 1, 2, 1}, false},
+		{"still", []uint16{0, 0, 0}, false},
+	}
+	ss := testSprites()
+	for _, tt := range tests {
+		var a Animator
+		ss.Play(&a, "test", tt.anim)
+		if !ss.Playing(&a, tt.anim) || a.Frame != 0 {
+			t.Fatalf("%s: didn't start playing", tt.anim)
+		}
+		for i, want := range tt.frames {
+			ss.Advance(&a, 10*time.Millisecond)
+			if a.Frame != want {
+				t.Errorf("%s: frame %d after %d steps, want %d", tt.anim, a.Frame, i+1, want)
+				break
+			}
+		}
+		if a.Done != tt.done {
+			t.Errorf("%s: done is %v", tt.anim, a.Done)
+		}
+	}
+}
+
+// a long step goes through as many frames as it covers, keeping what's left over
+func TestAnimationLongStep(t *testing.T) {
+	ss := testSprites()
+	var a Animator
+	ss.Play(&a, "test", "loop")
+	ss.Advance(&a, 45*time.Millisecond)
+	if a.Frame != 1 || a.Time != 5*time.Millisecond {
+		t.Errorf("at frame %d with %v left, want frame 1 with 5ms", a.Frame, a.Time)
+	}
+
+	_, frame, _, ok := ss.Frame(&a)
+	if !ok || frame.Pos.X != 32 {
+		t.Errorf("drawing %+v from the sheet, want the third frame", frame)
+	}
+}
+
+// animators come from saves and the network, so their numbers can be anything
+func TestAnimatorOutOfRange(t *testing.T) {
+	ss := testSprites()
+	bad := []Animator{
+		{Sheet: 2},
+		{Sheet: 1, Anim: 4},
+		{Sheet: 1, Anim: 0, Frame: 3},
+		{Sheet: 1, Anim: 3, Frame: 1},
+	}
+	for _, a := range bad {
+		ss.Advance(&a, time.Second)
+		if _, _, _, ok := ss.Frame(&a); ok {
+			t.Errorf("%+v has a frame to draw", a)
+		}
+	}
+
+	// playing what it says it's on starts it again from a frame that's there
+	a := Animator{Sheet: 1, Anim: 0, Frame: 3}
+	ss.Play(&a, "test", "loop")
+	if a.Frame != 0 {
+		t.Errorf("still on frame %d", a.Frame)
+	}
+	if _, _, _, ok := ss.Frame(&a); !ok {
+		t.Error("nothing to draw after playing again")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is what a scene publishes after each tick: the state it just
+// finished, the one before it, and how much time had built up towards the next
+// step when it was published, so the renderer can draw anywhere in between
+type Snapshot struct {
+	Prev      GameState
+	Cur       GameState
+	Published time.Time
+	Step      time.Duration
+	Leftover  time.Duration
+	TimeScale float64
+	Zoom      int32 // debug zoom, which is only for drawing
+}
+
+// set on StateBuffer.middle when it holds a state the reader hasn't seen yet
+const STATE_FRESH = 4
+
+// StateBuffer hands Snapshots from a scene's update goroutine to the
+// render thread without either side waiting on the other.
+//
+// it holds three snapshots. the writer owns one (Back), the reader owns one
+// (the one Latest returned), and the third sits in the middle holding the most
+// recently published snapshot. Publish and Latest swap the caller's buffer with
+// the middle one atomically, so a snapshot is only ever touched by one goroutine
+// at a time. states must not hold pointers into themselves, since they get
+// copied between buffers
+type StateBuffer struct {
+	states [3]Snapshot
+	middle uint32
+	write  int
+	read   int
+}
+
+func NewStateBuffer() *StateBuffer {
+	return &StateBuffer{middle: 1, write: 0, read: 2}
+}
+
+// the snapshot the writer fills in before publishing. only the writer may call this,
+// and the pointer is only good until the next Publish
+func (b *StateBuffer) Back() *Snapshot {
+	return &b.states[b.write]
+}
+
+// hand the back buffer to the reader. the writer gets the old middle buffer in
+// exchange, which may hold anything and has to be overwritten completely
+func (b *StateBuffer) Publish() {
+	old := atomic.SwapUint32(&b.middle, uint32(b.write)|STATE_FRESH)
+	b.write = int(old &^ STATE_FRESH)
+}
+
+// the most recently published snapshot. only the reader may call this, and the
+// pointer is only good until the next call. returns the same snapshot again if
+// nothing new has been published
+func (b *StateBuffer) Latest() *Snapshot {
+	if atomic.LoadUint32(&b.middle)&STATE_FRESH != 0 {
+		old := atomic.SwapUint32(&b.middle, uint32(b.read))
+		b.read = int(old &^ STATE_FRESH)
+	}
+	return &b.states[b.read]
+}
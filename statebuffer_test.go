@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"./tmx"
+)
+
+// the update and render sides hammering the buffer at once. this is mostly
+// here for the race detector, run it with go test -race
+func TestStateBufferConcurrent(t *testing.T) {
+	const publishes = 20000
+
+	b := NewStateBuffer()
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	// every snapshot is written so it can be checked for being torn
+	go func() {
+		defer wg.Done()
+		for i := uint64(1); i <= publishes; i++ {
+			snap := b.Back()
+			snap.Prev.Tick = i - 1
+			snap.Cur.Tick = i
+			snap.Cur.Entities.Ents[1].Pos.X = Fixed(i)
+			snap.Cur.Entities.Ents[MAX_ENTITIES-1].Pos.X = Fixed(i)
+			b.Publish()
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var last uint64
+	reads := 0
+	for {
+		finished := false
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		snap := b.Latest()
+		cur := &snap.Cur
+		if cur.Tick < last {
+			t.Fatalf("went back from tick %d to %d", last, cur.Tick)
+		}
+		if cur.Tick != 0 {
+			if snap.Prev.Tick != cur.Tick-1 ||
+				cur.Entities.Ents[1].Pos.X != Fixed(cur.Tick) ||
+				cur.Entities.Ents[MAX_ENTITIES-1].Pos.X != Fixed(cur.Tick) {
+				t.Fatalf("snapshot for tick %d is torn", cur.Tick)
+			}
+		}
+		last = cur.Tick
+		reads++
+
+		// once the writer is done the newest one has to be there
+		if finished {
+			if last != publishes {
+				t.Fatalf("last tick read was %d, want %d", last, publishes)
+			}
+			break
+		}
+	}
+	t.Logf("%d reads", reads)
+}
+
+// with nothing new published the reader keeps getting the same snapshot
+func TestStateBufferLatestRepeats(t *testing.T) {
+	b := NewStateBuffer()
+	b.Back().Cur.Tick = 7
+	b.Publish()
+
+	first := b.Latest()
+	if first.Cur.Tick != 7 {
+		t.Fatalf("got tick %d, want 7", first.Cur.Tick)
+	}
+	if again := b.Latest(); again != first {
+		t.Fatal("Latest moved without a publish")
+	}
+}
+
+// a scene being stepped on its own goroutine while the engine thread draws
+// it, the way they run in the game. also for go test -race, which catches
+// Render reaching into anything the simulation owns
+func TestUpdateWhileRendering(t *testing.T) {
+	level, err := LoadLevel("base/" + DEFAULT_LEVEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GameScene{Level: DEFAULT_LEVEL, Seed: 1, timeScale: 1, states: NewStateBuffer()}
+	if s.sprites, err = LoadSprites("base/" + SPRITES_FILE); err != nil {
+		t.Fatal(err)
+	}
+	s.setup(level)
+	s.publish()
+
+	const steps = 600
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < steps; i++ {
+			s.update(scriptedCommand(i))
+			s.publish()
+		}
+	}()
+
+	renders := 0
+	for finished := false; !finished; renders++ {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if cmds := s.Render(); cmds.NumCommands < 2 {
+			t.Fatalf("render %d only drew %d things", renders, cmds.NumCommands)
+		}
+	}
+
+	// everything that was stepped got published
+	if tick := s.states.Latest().Cur.Tick; tick != steps {
+		t.Errorf("last state drawn was tick %d, want %d", tick, steps)
+	}
+	t.Logf("%d renders", renders)
+}
+
+// more on screen than there's room for in a render list draws as much as fits
+func TestRenderFull(t *testing.T) {
+	level, err := LoadLevel("base/" + DEFAULT_LEVEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &GameScene{Level: DEFAULT_LEVEL, Seed: 1, timeScale: 1, states: NewStateBuffer()}
+	s.setup(level)
+
+	var tile *tmx.DecodedTile
+	for _, dt := range s.gmap.Layers[0].DecodedTiles {
+		if !dt.IsNil() {
+			tile = dt
+			break
+		}
+	}
+	cam := s.state.Camera
+	for i := 0; i < len(s.rcmds.Commands); i++ {
+		pos := Vec2{Fx(cam.Left + i%int(cam.Size.W)), Fx(cam.Top + i%int(cam.Size.H))}
+		s.scenery = append(s.scenery, SpawnObject{Pos: pos, Size: Size{16, 16}, Tile: tile})
+	}
+	for s.state.Entities.Spawn(ET_YORP) != nil {
+	}
+	s.publish()
+
+	if n := s.Render().NumCommands; int(n) != len(s.rcmds.Commands) {
+		t.Errorf("drew %d things", n)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/sdl_image"
+)
+
+const (
+	MAX_TEXTURES = 1024
+
+	MISSING_TEXTURE_NAME = "*missing*"
+	MISSING_TEXTURE_SIZE = 16
+)
+
+var (
+	ErrTextureTableFull = errors.New("texture table is full")
+	ErrInvalidTexture   = errors.New("invalid texture id")
+)
+
+// AssetError is returned when a file the game asked for couldn't be used
+type AssetError struct {
+	Path string
+	Err  error
+}
+
+func (e *AssetError) Error() string {
+	return fmt.Sprintf("failed to load %s: %s", e.Path, e.Err)
+}
+
+func (e *AssetError) Unwrap() error {
+	return e.Err
+}
+
+type textureSlot struct {
+	tex  *sdl.Texture
+	name string
+	refs int
+	pin  bool
+	w    int32
+	h    int32
+}
+
+// TextureManager owns every texture uploaded to the gpu. it must only be touched
+// from the engine thread. slot 0 is never handed out so a zero Image.Id can be
+// used to mean "no image"
+type TextureManager struct {
+	renderer *sdl.Renderer
+	slots    [MAX_TEXTURES]textureSlot
+	byName   map[string]int
+	free     []int
+	next     int
+	missing  Image
+}
+
+func NewTextureManager(renderer *sdl.Renderer) (*TextureManager, error) {
+	tm := &TextureManager{renderer: renderer, byName: make(map[string]int), next: 1}
+	if err := tm.createMissing(); err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
+// the checkerboard image handed out in place of anything that failed to load
+func (tm *TextureManager) Missing() Image {
+	return tm.missing
+}
+
+// load an image from disk and upload it, or add a reference to it if something
+// else already has it loaded
+func (tm *TextureManager) Load(fname string) (Image, error) {
+	if image, ok := tm.Ref(fname); ok {
+		return image, nil
+	}
+
+	surface, err := img.Load(fname)
+	if err != nil {
+		return tm.missing, &AssetError{Path: fname, Err: err}
+	}
+	// the surface is only needed until the texture exists
+	defer surface.Free()
+
+	return tm.Upload(fname, surface, 1)
+}
+
+// add a reference to an already resident texture
+func (tm *TextureManager) Ref(fname string) (Image, bool) {
+	id, ok := tm.byName[fname]
+	if !ok {
+		return Image{}, false
+	}
+
+	slot := &tm.slots[id]
+	slot.refs++
+	return Image{Id: id, W: slot.w, H: slot.h}, true
+}
+
+// create a texture from an already decoded surface, starting it with the given
+// number of references. the caller still owns the surface
+func (tm *TextureManager) Upload(fname string, surface *sdl.Surface, refs int) (Image, error) {
+	id, err := tm.alloc()
+	if err != nil {
+		return tm.missing, &AssetError{Path: fname, Err: err}
+	}
+
+	texture, err := tm.renderer.CreateTextureFromSurface(surface)
+	if err != nil {
+		tm.free = append(tm.free, id)
+		return tm.missing, &AssetError{Path: fname, Err: err}
+	}
+
+	_, _, w, h, _ := texture.Query()
+	tm.slots[id] = textureSlot{tex: texture, name: fname, refs: refs, w: w, h: h}
+	tm.byName[fname] = id
+
+	return Image{Id: id, W: w, H: h}, nil
+}
+
+// drop a reference to a texture, destroying it and recycling the slot once
+// nothing is using it anymore
+func (tm *TextureManager) Release(id int) error {
+	if id <= 0 || id >= MAX_TEXTURES || tm.slots[id].tex == nil {
+		return ErrInvalidTexture
+	}
+
+	slot := &tm.slots[id]
+	if slot.pin {
+		return nil
+	}
+
+	slot.refs--
+	if slot.refs > 0 {
+		return nil
+	}
+
+	slot.tex.Destroy()
+	delete(tm.byName, slot.name)
+	*slot = textureSlot{}
+	tm.free = append(tm.free, id)
+
+	return nil
+}
+
+// returns the texture in a slot, or nil if the slot is empty
+func (tm *TextureManager) Get(id int32) *sdl.Texture {
+	if id <= 0 || id >= MAX_TEXTURES {
+		return nil
+	}
+	return tm.slots[id].tex
+}
+
+// destroy every texture regardless of reference count. used at shutdown
+func (tm *TextureManager) Destroy() {
+	for i := range tm.slots {
+		if tm.slots[i].tex != nil {
+			tm.slots[i].tex.Destroy()
+		}
+		tm.slots[i] = textureSlot{}
+	}
+	tm.byName = make(map[string]int)
+	tm.free = tm.free[:0]
+	tm.next = 1
+	tm.missing = Image{}
+}
+
+// build a magenta and black checkerboard and pin it so it's never released
+func (tm *TextureManager) createMissing() error {
+	surface, err := sdl.CreateRGBSurface(0, MISSING_TEXTURE_SIZE, MISSING_TEXTURE_SIZE, 32, 0x00ff0000, 0x0000ff00, 0x000000ff, 0xff000000)
+	if err != nil {
+		return err
+	}
+	defer surface.Free()
+
+	half := int32(MISSING_TEXTURE_SIZE / 2)
+	surface.FillRect(nil, 0xff000000)
+	surface.FillRect(&sdl.Rect{X: 0, Y: 0, W: half, H: half}, 0xffff00ff)
+	surface.FillRect(&sdl.Rect{X: half, Y: half, W: half, H: half}, 0xffff00ff)
+
+	tm.missing, err = tm.Upload(MISSING_TEXTURE_NAME, surface, 1)
+	if err != nil {
+		return err
+	}
+	tm.slots[tm.missing.Id].pin = true
+
+	return nil
+}
+
+// find an unused slot, preferring ones that have been released
+func (tm *TextureManager) alloc() (int, error) {
+	if n := len(tm.free); n > 0 {
+		id := tm.free[n-1]
+		tm.free = tm.free[:n-1]
+		return id, nil
+	}
+
+	if tm.next >= MAX_TEXTURES {
+		return 0, ErrTextureTableFull
+	}
+
+	id := tm.next
+	tm.next++
+	return id, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+
+	"./tmx"
+)
+
+// TileAnimations holds the animated tiles from each of a map's tilesets, so
+// that drawing can swap in whichever frame is showing. which frame that is
+// only depends on how much time has been simulated, so animations stop when
+// the game is paused and every client sees the same frame on the same step
+type TileAnimations struct {
+	tilesets map[*tmx.Tileset]map[tmx.ID]*tileAnim
+}
+
+type tileAnim struct {
+	frames []tmx.ID
+	ends   []time.Duration // when each frame stops showing, from the start of the loop
+}
+
+func NewTileAnimations(m *tmx.Map) *TileAnimations {
+	ta := &TileAnimations{tilesets: make(map[*tmx.Tileset]map[tmx.ID]*tileAnim)}
+
+	for i := range m.Tilesets {
+		ts := &m.Tilesets[i]
+		for _, tile := range ts.Tiles {
+			if len(tile.Animation) == 0 {
+				continue
+			}
+
+			anim := &tileAnim{}
+			var end time.Duration
+			for _, frame := range tile.Animation {
+				d := time.Duration(frame.Duration) * time.Millisecond
+				if d <= 0 {
+					d = DEFAULT_FRAME_TIME
+				}
+				end += d
+				anim.frames = append(anim.frames, frame.TileID)
+				anim.ends = append(anim.ends, end)
+			}
+
+			if ta.tilesets[ts] == nil {
+				ta.tilesets[ts] = make(map[tmx.ID]*tileAnim)
+			}
+			ta.tilesets[ts][tile.ID] = anim
+		}
+	}
+
+	return ta
+}
+
+// the tile to draw in place of a tile after t of simulated time
+func (ta *TileAnimations) Frame(ts *tmx.Tileset, id tmx.ID, t time.Duration) tmx.ID {
+	anims, ok := ta.tilesets[ts]
+	if !ok {
+		return id
+	}
+	anim, ok := anims[id]
+	if !ok {
+		return id
+	}
+
+	t %= anim.ends[len(anim.ends)-1]
+	for i, end := range anim.ends {
+		if t < end {
+			return anim.frames[i]
+		}
+	}
+	return id
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"./tmx"
+)
+
+func TestTileAnimationFrames(t *testing.T) {
+	m := &tmx.Map{Tilesets: []tmx.Tileset{{
+		Tiles: []tmx.Tile{
+			{ID: 5, Animation: []tmx.Frame{
+				{TileID: 5, Duration: 100},
+				{TileID: 6, Duration: 50},
+				{TileID: 7},
+			}},
+			{ID: 9},
+		},
+	}}}
+	ta := NewTileAnimations(m)
+	ts := &m.Tilesets[0]
+	ms := time.Millisecond
+
+	// a frame with no duration shows for DEFAULT_FRAME_TIME, so the loop is 250ms
+	tests := []struct {
+		t    time.Duration
+		want tmx.ID
+	}{
+		{0, 5},
+		{99 * ms, 5},
+		{100 * ms, 6},
+		{149 * ms, 6},
+		{150 * ms, 7},
+		{249 * ms, 7},
+		{250 * ms, 5},
+		{2*250*ms + 120*ms, 6},
+		{1000*250*ms + 200*ms, 7},
+	}
+	for _, tt := range tests {
+		if got := ta.Frame(ts, 5, tt.t); got != tt.want {
+			t.Errorf("frame at %v is %d, want %d", tt.t, got, tt.want)
+		}
+	}
+
+	// tiles without an animation, or from a tileset without any, stay as they are
+	if got := ta.Frame(ts, 9, 120*ms); got != 9 {
+		t.Errorf("still tile drawn as %d", got)
+	}
+	if got := ta.Frame(&tmx.Tileset{}, 5, 120*ms); got != 5 {
+		t.Errorf("tile from another tileset drawn as %d", got)
+	}
+}
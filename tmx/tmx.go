@@ -94,8 +94,16 @@ type Image struct {
 }
 
 type Tile struct {
-	ID    ID    `xml:"id,attr"`
-	Image Image `xml:"image"`
+	ID         ID         `xml:"id,attr"`
+	Image      Image      `xml:"image"`
+	Properties []Property `xml:"properties>property"`
+	Animation  []Frame    `xml:"animation>frame"`
+}
+
+// Frame is one step of a tile animation. Duration is in milliseconds.
+type Frame struct {
+	TileID   ID  `xml:"tileid,attr"`
+	Duration int `xml:"duration,attr"`
 }
 
 type Layer struct {
@@ -1,6 +1,9 @@
 package main
 
-import "sync"
+import (
+	"context"
+	"errors"
+)
 
 func btoi(a bool) int {
 	if a {
@@ -33,13 +36,36 @@ func clamp(min int, i int, max int) int {
 	}
 }
 
+// linear interpolation between a and b, t is expected to be between 0 and 1
+func lerp(a, b int32, t float64) int32 {
+	return a + int32(float64(b-a)*t)
+}
+
 type Entity struct {
-	Valid bool
-	Pos   Vector
-	Vel   Vector
-	Size  Size
-	Color RGBA
-	Image int
+	Valid   bool
+	Id      EntityId
+	Type    EntityType
+	Variant uint8 // which kind of its type it is, like what a collectable is worth
+	State   uint8 // what it's doing, the meaning depends on its type
+	Timer   int32 // steps left in the current state
+	Dir     int32 // which way it faces, -1 for left and 1 for right
+	Pos     Vec2
+	Vel     Vec2
+	Size    Size
+	Color   RGBA // drawn as a rectangle this colour if it has no sprite
+	Anim    Animator
+	Body    Body
+	Group   uint32 // collision groups this entity is in
+	Mask    uint32 // collision groups this entity touches
+	Held    uint32 // buttons held last step, to tell presses from holds
+	Inv     Inventory
+}
+
+// what a player has picked up
+type Inventory struct {
+	Score int32
+	Ammo  int32
+	Pogo  bool
 }
 
 type Vector struct {
@@ -52,6 +78,18 @@ type Size struct {
 	H int32
 }
 
+// Rect is an area in pixels
+type Rect struct {
+	X int32
+	Y int32
+	W int32
+	H int32
+}
+
+func (r Rect) Contains(p Vector) bool {
+	return p.X >= r.X && p.X < r.X+r.W && p.Y >= r.Y && p.Y < r.Y+r.H
+}
+
 type RGBA struct {
 	R uint8
 	G uint8
@@ -59,12 +97,35 @@ type RGBA struct {
 	A uint8
 }
 
+// GameState is a plain value so it can be copied wholesale between the update
+// and render goroutines. nothing in it may point back into itself, which is
+// why entities refer to each other by EntityId
 type GameState struct {
-	Time      int32
-	FrameTime int32
-	Entities  [1024]Entity
-	Camera    Camera
-	LocalEnt  *Entity
+	Tick     uint64 // number of fixed steps simulated so far
+	Rand     uint32 // see Random
+	Entities EntityList
+	Camera   Camera
+	LocalEnt EntityId
+}
+
+// a random number from 0 to n-1. the generator lives in the state so that a
+// state always plays out the same way from there, however it was arrived at.
+// anything asking for a number below 1 gets 0
+func (st *GameState) Random(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	// xorshift, which gets stuck on zero
+	x := st.Rand
+	if x == 0 {
+		x = 1
+	}
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	st.Rand = x
+	return int(x % uint32(n))
 }
 
 type EventType int
@@ -74,46 +135,79 @@ const (
 	EV_MOUSEMOVE
 	EV_MOUSECLICK
 	EV_MOUSEWHEEL
+	EV_RESIZE     // the screen a scene draws to changed size, EvData1 by EvData2
+	EV_PADADDED   // a gamepad was plugged in
+	EV_PADREMOVED // and unplugged
+	EV_PADBUTTON  // EvData1 is the button
+	EV_PADAXIS    // EvData1 is the axis, EvData2 how far it's pushed from -32768 to 32767
 )
 
 type Event struct {
 	Type     EventType
 	Down     bool
-	Position Vector
+	Position Vector // on the screen for mouse events
 	EvData1  int
 	EvData2  int
+	Pad      int // which gamepad slot, for EV_PAD events
 }
 
+// scenes are started by the SceneManager, which calls Load in a new goroutine.
+// Load should keep running until sceneCh.Ctx is cancelled, after which Unload
+// is called on the same goroutine to save anything worth keeping and hand
+// resources back to the engine. the engine keeps answering engine commands
+// until Unload returns. Ready and Render are called from the engine thread
 type Scene interface {
 	Load(sceneCh SceneChannels)
 	Unload()
+	Ready() bool
+	Render() *RenderCommandList
 }
 
+// buttons in UserCommand.Buttons
+const (
+	BT_JUMP uint32 = 1 << iota
+	BT_SHOOT
+	BT_POGO
+)
+
 type UserCommand struct {
-	Up    int
-	Down  int
-	Left  int
-	Right int
+	Up      int
+	Down    int
+	Left    int
+	Right   int
+	Buttons uint32 // BT_ flags held down
+	Mouse   Vector // where the mouse is pointing, in world pixels
 }
 
 type SceneChannels struct {
-	RCmd      chan *RenderCommandList
-	Ev        chan Event
-	Eng       chan EngineCommand
-	Err       chan error
-	stateLock sync.Mutex
+	RCmd chan *RenderCommandList
+	Ev   *EventQueue
+	Eng  chan EngineCommand
+	Err  chan error
+	Ctx  context.Context
 }
 
 type ECmd int
 
 const (
 	EC_LOADIMAGE ECmd = 1 + iota
+	EC_RELEASEIMAGE
+	EC_LOADIMAGES
+	EC_PUSHSCENE
+	EC_POPSCENE
+	EC_REPLACESCENE
+	EC_RUMBLE
 )
 
+var ErrUnknownCommand = errors.New("unknown engine command")
+
+// replies with Success false carry the reason in Err. Data may still be usable,
+// failed image loads come back with the missing texture
 type EngineCommand struct {
 	Id      ECmd
 	Success bool
 	Data    interface{}
+	Err     error
 }
 
 type Image struct {
@@ -142,31 +236,6 @@ type RenderCommand struct {
 	ImageId   int32
 	ImgPos    Vector
 	ImgSize   Size
+	Flip      bool // mirror the image left to right
 	BackColor RGBA
 }
-
-type Camera struct {
-	Left   int
-	Right  int
-	Top    int
-	Bottom int
-	Bounds Size
-	Size   Size
-}
-
-func (s *Camera) Set(pos Vector) {
-	s.Left = clamp(0, int(pos.X), int(s.Bounds.W-s.Size.W))
-	s.Top = clamp(0, int(pos.Y), int(s.Bounds.H-s.Size.H))
-	s.Right = s.Left + int(s.Size.W)
-	s.Bottom = s.Top + int(s.Size.H)
-}
-
-func (s *Camera) SetSize(sz Size) {
-	s.Size = sz
-	s.Set(Vector{int32(s.Left), int32(s.Top)})
-}
-
-func (s *Camera) SetBounds(b Size) {
-	s.Bounds = b
-	s.Set(Vector{int32(s.Left), int32(s.Top)})
-}